@@ -1,39 +1,63 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/streadway/amqp"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"payment-service-go/clickhouse"
 	"payment-service-go/exchanger"
+	"payment-service-go/metrics"
 	"payment-service-go/models"
+	"payment-service-go/mysql"
 	"payment-service-go/rabbit"
+	"payment-service-go/redact"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// maxConcurrent - предел одновременно обрабатываемых сообщений
+const maxConcurrent = 50
+
 type App struct {
-	isProcessing      int32
 	isProcessingCheck int32
 	rabbitConn        *rabbit.RabbitMQ
-	channel           *amqp.Channel
-	consumer          <-chan amqp.Delivery
+	consumer          *rabbit.Consumer
+	events            *rabbit.EventPublisher
+	workers           int
+	http              *http.Server
+
+	stopping  int32          // 1 - идёт остановка, новые сообщения возвращаются в очередь
+	inFlight  int32          // сообщений в обработке
+	workersWg sync.WaitGroup // воркеры очереди
+	checkWg   sync.WaitGroup // цикл ProcessInvoices
 }
 
 func NewApp(rabbitConn *rabbit.RabbitMQ) (*App, error) {
-	ch, err := rabbitConn.NewChannel()
-	if err != nil {
-		return nil, err
+	workers := envInt("QUEUE_WORKERS", 10)
+	if workers <= 0 || workers > maxConcurrent {
+		log.Printf("QUEUE_WORKERS=%d вне диапазона 1..%d, используется %d", workers, maxConcurrent, maxConcurrent)
+		workers = maxConcurrent
 	}
-	consumer, err := ch.Consume("invoices", "", false, false, false, false, nil)
+	prefetch := envInt("QUEUE_PREFETCH", workers)
+
+	hostname, _ := os.Hostname()
+	consumerTag := fmt.Sprintf("payment-service-%s-%d", hostname, os.Getpid())
+	consumer, err := rabbitConn.NewConsumer("invoices", consumerTag, prefetch)
 	if err != nil {
-		log.Printf("Ошибка потребителя: %v", err)
-		ch.Close()
 		return nil, err
 	}
-	return &App{isProcessing: 0, isProcessingCheck: 0, rabbitConn: rabbitConn, channel: ch, consumer: consumer}, nil
+	log.Printf("Потребитель запущен: workers=%d, prefetch=%d", workers, prefetch)
+	return &App{isProcessingCheck: 0, rabbitConn: rabbitConn, consumer: consumer, workers: workers}, nil
 }
 
 func main() {
@@ -46,153 +70,262 @@ func main() {
 	rbPort := os.Getenv("RABBITMQ_PORT")
 	rbUser := os.Getenv("RABBITMQ_USER")
 	rbPass := os.Getenv("RABBITMQ_PASSWORD")
+	// Vhost в AMQP URL - путь, поэтому "/" передаётся как %2F
+	rbVhost := url.PathEscape(os.Getenv("RABBITMQ_VHOST"))
 
-	rabbitConn, err := rabbit.NewRabbitMQ("amqp://" + rbUser + ":" + rbPass + "@" + rbHost + ":" + rbPort + "/")
+	rabbitConn, err := rabbit.NewRabbitMQ("amqp://"+rbUser+":"+rbPass+"@"+rbHost+":"+rbPort+"/"+rbVhost, rabbit.LoadRetryPolicy())
 	if err != nil {
 		log.Fatalf("RabbitMQ error: %v", err)
 	}
-	defer rabbitConn.Close()
 
 	app, err := NewApp(rabbitConn)
 	if err != nil {
 		log.Fatalf("App error: %v", err)
 	}
-	defer app.channel.Close()
 
-	processor := exchanger.NewProcessor()
-	app.startProcessing(processor)
+	redactor, err := redact.Load()
+	if err != nil {
+		log.Fatalf("Ошибка настройки маскирования: %v", err)
+	}
+	redact.SetDefault(redactor)
+
+	if dir := os.Getenv("EXCHANGER_DEFINITIONS_DIR"); dir != "" {
+		if err := exchanger.LoadRestDefinitions(dir); err != nil {
+			log.Fatalf("Ошибка загрузки описаний обменников: %v", err)
+		}
+	}
+
+	mysqlDB, err := mysql.NewMySQLDB()
+	if err != nil {
+		log.Fatalf("Ошибка MySQL logger: %v", err)
+	}
+	clickDB, err := clickhouse.NewClickDB()
+	if err != nil {
+		log.Fatalf("Ошибка Clickhouse logger: %v", err)
+	}
+	if err := prepareSchema(LoadSchemaConfig(), mysqlDB, clickDB); err != nil {
+		log.Fatalf("Ошибка схемы БД: %v", err)
+	}
+
+	app.events = rabbitConn.NewEventPublisher()
+	processor, err := exchanger.NewProcessor(mysqlDB, clickDB, app.events)
+	if err != nil {
+		log.Fatalf("Processor error: %v", err)
+	}
+	app.startHTTP(envString("HTTP_ADDR", ":8080"), processor)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	app.startProcessing(ctx, processor)
+	app.shutdown(processor, envDuration("SHUTDOWN_TIMEOUT", 25*time.Second))
 }
 
-func (a *App) startProcessing(processor *exchanger.Processor) {
-	// Ticker для обработки очереди
-	tickerQueue := time.NewTicker(20 * time.Second)
-	defer tickerQueue.Stop()
-	go func() {
-		log.Println("Запуск процессинга очереди RabbitMQ…")
-		for range tickerQueue.C {
-			a.processQueue(processor)
+// watchQueueDepth периодически обновляет метрику глубины очереди задач и dead_letter_queue, interval <= 0 - выключено
+func (a *App) watchQueueDepth(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, queue := range []string{"invoices", "dead_letter_queue"} {
+			depth, err := a.rabbitConn.QueueDepth(queue)
+			if err != nil {
+				log.Printf("Не удалось получить глубину очереди %s: %v", queue, err)
+				continue
+			}
+			metrics.QueueDepth.WithLabelValues(queue).Set(float64(depth))
 		}
-	}()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *App) startProcessing(ctx context.Context, processor *exchanger.Processor) {
+	// Пул воркеров читает сообщения из канала потребителя по мере поступления
+	log.Println("Запуск процессинга очереди RabbitMQ…")
+	for i := 0; i < a.workers; i++ {
+		a.workersWg.Add(1)
+		go a.worker(processor)
+	}
+
+	go a.watchQueueDepth(ctx, envDuration("METRICS_QUEUE_INTERVAL", 15*time.Second))
 
 	// Ticker для ProcessInvoices
-	tickerCheck := time.NewTicker(2 * time.Minute)
-	defer tickerCheck.Stop()
+	a.checkWg.Add(1)
 	go func() {
+		defer a.checkWg.Done()
+		tickerCheck := time.NewTicker(2 * time.Minute)
+		defer tickerCheck.Stop()
+
 		log.Println("Запуск проверки счетов…")
-		for range tickerCheck.C {
-			if err := processor.ProcessInvoices(); err != nil {
-				log.Printf("Ошибка при ProcessInvoices: %v", err)
+		for {
+			select {
+			case <-tickerCheck.C:
+				if err := processor.ProcessInvoices(); err != nil {
+					log.Printf("Ошибка при ProcessInvoices: %v", err)
+				}
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
-	// Блокируем main-горутину (или ждём сигнала на shutdown)
-	select {}
+	// Ждём сигнала на shutdown
+	<-ctx.Done()
+	log.Println("Получен сигнал остановки")
 }
 
-func (a *App) processQueue(processor *exchanger.Processor) {
-	if !a.startQueueProcessing() {
-		log.Println("Обработка идёт, пропуск")
-		return
-	}
-	defer a.stopQueueProcessing()
+// shutdown прекращает потребление, дожидается текущих задач до дедлайна
+// и закрывает MySQL, ClickHouse и RabbitMQ
+func (a *App) shutdown(processor *exchanger.Processor, timeout time.Duration) {
+	atomic.StoreInt32(&a.stopping, 1)
 
-	msgs, err := a.getMessages()
-	if err != nil {
-		log.Printf("Ошибка сообщений: %v", err)
-		return
+	// После отмены брокер перестаёт выдавать сообщения, уже полученные воркеры вернут в очередь
+	if err := a.consumer.Cancel(); err != nil {
+		log.Printf("Ошибка отмены потребителя: %v", err)
 	}
-	if len(msgs) == 0 {
-		log.Println("Очередь пуста")
-		return
-	}
-	log.Printf("Получено %d сообщений", len(msgs))
 
-	const maxConcurrent = 50
-	sem := make(chan struct{}, maxConcurrent)
-	var wg sync.WaitGroup
+	done := make(chan struct{})
+	go func() {
+		a.workersWg.Wait()
+		a.checkWg.Wait()
+		close(done)
+	}()
 
-	for _, msg := range msgs {
-		sem <- struct{}{}
-		wg.Add(1)
-		go func(msg amqp.Delivery) {
-			defer wg.Done()
-			defer func() { <-sem }()
-			a.handleMessage(msg, processor)
-		}(msg)
+	select {
+	case <-done:
+		log.Println("Все задачи завершены")
+	case <-time.After(timeout):
+		log.Printf("Дедлайн остановки истёк, в обработке осталось %d сообщений", atomic.LoadInt32(&a.inFlight))
 	}
-	wg.Wait()
-}
 
-func (a *App) getMessages() ([]amqp.Delivery, error) {
-	var messages []amqp.Delivery
-	timeout := time.After(2 * time.Second)
-	for len(messages) < 100 {
-		select {
-		case msg, ok := <-a.consumer:
-			if !ok {
-				log.Println("Канал закрыт")
-				return messages, nil
-			}
-			messages = append(messages, msg)
-			log.Printf("Сообщение: %s", msg.Body)
-		case <-timeout:
-			log.Printf("Сбор завершён, сообщений: %d", len(messages))
-			return messages, nil
-		}
+	// Неподтверждённые сообщения брокер вернёт в очередь при закрытии канала
+	if err := a.consumer.Close(); err != nil {
+		log.Printf("Ошибка закрытия канала: %v", err)
 	}
-	log.Printf("Лимит достигнут, сообщений: %d", len(messages))
-	return messages, nil
+	a.stopHTTP()
+	processor.Close()
+	a.events.Close()
+	a.rabbitConn.Close()
+	log.Println("Сервис остановлен")
 }
 
-func (a *App) startQueueProcessing() bool {
-	return atomic.CompareAndSwapInt32(&a.isProcessing, 0, 1)
-}
+func (a *App) worker(processor *exchanger.Processor) {
+	defer a.workersWg.Done()
+
+	for msg := range a.consumer.Deliveries() {
+		if atomic.LoadInt32(&a.stopping) == 1 {
+			msg.Nack(false, true)
+			metrics.Messages.WithLabelValues(metrics.OutcomeNackRequeue).Inc()
+			continue
+		}
+		log.Printf("Сообщение: %s", redact.Default().Body("", string(msg.Body)))
 
-func (a *App) stopQueueProcessing() {
-	atomic.StoreInt32(&a.isProcessing, 0)
+		atomic.AddInt32(&a.inFlight, 1)
+		a.handleMessage(msg, processor)
+		atomic.AddInt32(&a.inFlight, -1)
+	}
+	log.Println("Канал закрыт")
 }
 
 func (a *App) handleMessage(msg amqp.Delivery, processor *exchanger.Processor) {
 	task, err := a.parseTask(msg.Body)
 	if err != nil {
-		msg.Nack(false, false)
+		a.deadLetter(msg, "JSON ошибка: "+err.Error())
 		log.Printf("JSON ошибка: %v", err)
 		return
 	}
+	observeConsumeLag(msg, task)
 	if err := task.Validate(); err != nil {
-		msg.Nack(false, false)
-		processor.MysqlLogger.CustomQuery(
-			"UPDATE invoices SET status = ?, updated_at = ? WHERE id = ?",
-			"cancel_invalid",
-			time.Now().Format("2006-01-02 15:04:05"),
-			task.Invoice.ID,
-		)
-		processor.ClickLogger.InvoiceHistoryInsert(task.Invoice.ID, "golang_handle_message", "cancel_invalid", nil, nil)
+		a.deadLetter(msg, "Невалидная задача: "+err.Error())
+		processor.ChangeInvoiceStatus(models.InvoiceCheckLite{ID: task.Invoice.ID}, models.StatusCancelInvalid, "golang_handle_message", nil)
 
 		processor.ClickLogger.LogErrorInvoice(task.Invoice, "Невалидная задача: "+err.Error())
 		log.Printf("Невалидная задача %d: %v", task.Invoice.ID, err)
 		return
 	}
 	if a.isTaskExpired(task.Invoice.CreatedAt) {
-		msg.Nack(false, false)
-		processor.MysqlLogger.CustomQuery(
-			"UPDATE invoices SET status = ?, updated_at = ? WHERE id = ?",
-			"cancel_search",
-			time.Now().Format("2006-01-02 15:04:05"),
-			task.Invoice.ID,
-		)
-		processor.ClickLogger.InvoiceHistoryInsert(task.Invoice.ID, "golang_handle_message", "cancel_search", nil, nil)
+		a.deadLetter(msg, "Заявка просрочена")
+		processor.ChangeInvoiceStatus(models.InvoiceCheckLite{ID: task.Invoice.ID}, models.StatusCancelSearch, "golang_handle_message", nil)
 		log.Printf("Заявка %d просрочена", task.Invoice.ID)
 		return
 	}
 	if a.processTask(processor, task) {
 		msg.Ack(false)
+		metrics.Messages.WithLabelValues(metrics.OutcomeAck).Inc()
 		log.Printf("Заявка %d обработана", task.Invoice.ID)
 	} else {
-		msg.Nack(false, true)
-		log.Printf("Заявка %d: реквизиты не найдены", task.Invoice.ID)
+		if err := a.rabbitConn.Retry(msg, "реквизиты не найдены"); err != nil {
+			msg.Nack(false, true)
+			metrics.Messages.WithLabelValues(metrics.OutcomeNackRequeue).Inc()
+		}
+		log.Printf("Заявка %d: реквизиты не найдены, попытка %d", task.Invoice.ID, rabbit.Attempt(msg))
+	}
+}
+
+// deadLetter отправляет сообщение в dead_letter_queue с причиной,
+// при ошибке публикации полагается на x-dead-letter-exchange очереди
+func (a *App) deadLetter(msg amqp.Delivery, reason string) {
+	if err := a.rabbitConn.DeadLetter(msg, reason); err != nil {
+		// Брокер переложит сообщение в dead_letter_queue через x-dead-letter-exchange
+		msg.Nack(false, false)
+		metrics.Messages.WithLabelValues(metrics.OutcomeDeadLetter).Inc()
+	}
+}
+
+// observeConsumeLag учитывает задержку первой доставки задачи: от времени публикации,
+// а если издатель его не задал - от создания счета. Повторы откладываются намеренно и не учитываются
+func observeConsumeLag(msg amqp.Delivery, task models.InvoiceTask) {
+	if rabbit.Attempt(msg) > 0 {
+		return
+	}
+	published := msg.Timestamp
+	if published.IsZero() {
+		published = task.Invoice.CreatedAt
+	}
+	if !published.IsZero() {
+		metrics.ConsumeLag.Observe(time.Since(published).Seconds())
+	}
+}
+
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%s: %v", key, value, err)
+		return def
+	}
+	return n
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%s: %v", key, value, err)
+		return def
 	}
+	return d
 }
 
 func (a *App) parseTask(body []byte) (models.InvoiceTask, error) {
@@ -210,6 +343,6 @@ func (a *App) processTask(processor *exchanger.Processor, task models.InvoiceTas
 		log.Printf("Ошибка заявки %d: %v", task.Invoice.ID, err)
 		return false
 	}
-	log.Printf("Реквизиты %d: %s", task.Invoice.ID, requisites)
+	log.Printf("Реквизиты %d: %s", task.Invoice.ID, redact.Default().Mask(requisites))
 	return true
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"payment-service-go/callback"
+	"payment-service-go/exchanger"
+	"payment-service-go/metrics"
+	"payment-service-go/rabbit"
+	"time"
+)
+
+// startHTTP поднимает HTTP-сервер: служебные маршруты, метрики Prometheus и приём уведомлений обменников
+func (a *App) startHTTP(addr string, processor *exchanger.Processor) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.handleHealth)
+	mux.Handle("/metrics", metrics.Handler())
+	callback.NewHandler(processor).Mount(mux)
+
+	a.http = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		log.Printf("HTTP-сервер запущен на %s", addr)
+		if err := a.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Ошибка HTTP-сервера: %v", err)
+		}
+	}()
+}
+
+func (a *App) stopHTTP() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.http.Shutdown(ctx); err != nil {
+		log.Printf("Ошибка остановки HTTP-сервера: %v", err)
+	}
+}
+
+// handleHealth отдаёт состояние подключения к RabbitMQ, 503 - пока идёт переподключение
+func (a *App) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := a.rabbitConn.Health()
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.State != rabbit.StateConnected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rabbitmq": health,
+	})
+}
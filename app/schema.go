@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"payment-service-go/clickhouse"
+	"payment-service-go/mysql"
+)
+
+// Режимы проверки схемы БД при старте
+const (
+	SchemaCheckStrict = "strict" // расхождение останавливает запуск
+	SchemaCheckWarn   = "warn"   // расхождение только пишется в лог
+	SchemaCheckOff    = "off"
+)
+
+// SchemaConfig - миграции и проверка схемы MySQL и ClickHouse при старте.
+// Миграции меняют общую с основным приложением БД, поэтому включаются только явно
+type SchemaConfig struct {
+	Migrate bool
+	Check   string
+}
+
+// LoadSchemaConfig читает настройки из окружения
+func LoadSchemaConfig() SchemaConfig {
+	cfg := SchemaConfig{
+		Migrate: envString("MIGRATIONS_AUTO", "false") == "true",
+		Check:   envString("SCHEMA_CHECK", SchemaCheckStrict),
+	}
+	switch cfg.Check {
+	case SchemaCheckStrict, SchemaCheckWarn, SchemaCheckOff:
+	default:
+		log.Printf("Неизвестный SCHEMA_CHECK=%s, используется %s", cfg.Check, SchemaCheckStrict)
+		cfg.Check = SchemaCheckStrict
+	}
+	return cfg
+}
+
+// prepareSchema накатывает миграции и сверяет схему MySQL и ClickHouse с ожидаемой.
+// Недоступный при старте ClickHouse не мешает запуску: журналы копятся на диске
+func prepareSchema(cfg SchemaConfig, mysqlDB *mysql.MySQLDB, clickDB *clickhouse.ClickDB) error {
+	clickAvailable := clickDB.Available()
+	if !clickAvailable {
+		log.Println("ClickHouse недоступен, миграции и проверка схемы ClickHouse пропущены")
+	}
+
+	if cfg.Migrate {
+		if err := mysqlDB.Migrate(); err != nil {
+			return fmt.Errorf("миграции MySQL: %w", err)
+		}
+		if clickAvailable {
+			if err := clickDB.Migrate(); err != nil {
+				return fmt.Errorf("миграции ClickHouse: %w", err)
+			}
+		}
+	}
+
+	if cfg.Check == SchemaCheckOff {
+		return nil
+	}
+	checks := []func() error{mysqlDB.CheckSchema}
+	if clickAvailable {
+		checks = append(checks, clickDB.CheckSchema)
+	}
+	for _, check := range checks {
+		if err := check(); err != nil {
+			if cfg.Check == SchemaCheckStrict {
+				return err
+			}
+			log.Printf("Проверка схемы: %v", err)
+		}
+	}
+	return nil
+}
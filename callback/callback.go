@@ -0,0 +1,69 @@
+package callback
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"payment-service-go/exchanger"
+	"payment-service-go/redact"
+	"strings"
+)
+
+// maxBodySize - предел размера тела уведомления
+const maxBodySize = 1 << 20
+
+// Handler принимает уведомления обменников о статусе заявок
+type Handler struct {
+	processor *exchanger.Processor
+}
+
+// NewHandler - конструктор
+func NewHandler(processor *exchanger.Processor) *Handler {
+	return &Handler{processor: processor}
+}
+
+// Mount регистрирует маршрут /callback/<имя обменника> для каждого обменника, принимающего уведомления
+func (h *Handler) Mount(mux *http.ServeMux) {
+	for _, name := range exchanger.CallbackReceivers() {
+		path := "/callback/" + strings.ToLower(name)
+		mux.HandleFunc(path, h.route(name))
+		log.Printf("Callback обменника %s: %s", name, path)
+	}
+}
+
+func (h *Handler) route(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err = h.processor.HandleCallback(name, r.Header, body)
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("OK"))
+		case errors.Is(err, exchanger.ErrCallbackSignature):
+			// Заголовки нужны для разбора расхождения подписи, ключи и подпись маскируются правилами обменника
+			log.Printf("[%s] callback отклонён: %v, заголовки: %v", name, err, redact.Default().Headers(name, r.Header))
+			w.WriteHeader(http.StatusUnauthorized)
+		case errors.Is(err, exchanger.ErrCallbackPayload):
+			log.Printf("[%s] callback: %v, body: %s", name, err, redact.Default().Body(name, string(body)))
+			w.WriteHeader(http.StatusBadRequest)
+		case errors.Is(err, exchanger.ErrCallbackInvoiceNotFound):
+			log.Printf("[%s] callback: %v", name, err)
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			// Обменник повторит уведомление
+			log.Printf("[%s] ошибка обработки callback: %v, body: %s", name, err, redact.Default().Body(name, string(body)))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
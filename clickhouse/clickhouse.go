@@ -7,11 +7,13 @@ import (
 	"log"
 	"os"
 	"payment-service-go/models"
+	"payment-service-go/redact"
 	"time"
 )
 
 type ClickDB struct {
-	db *sql.DB
+	db     *sql.DB
+	writer *Writer
 }
 
 func NewClickDB() (*ClickDB, error) {
@@ -28,173 +30,175 @@ func NewClickDB() (*ClickDB, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Без ClickHouse сервис стартует: журналы копятся на диске до восстановления соединения
+	healthy := true
 	if err := db.Ping(); err != nil {
+		log.Printf("ClickHouse недоступен, запись идёт на диск: %v", err)
+		healthy = false
+	} else {
+		log.Println("ClickHouse подключен")
+	}
+
+	writer, err := NewWriter(db, LoadWriterConfig(), healthy)
+	if err != nil {
 		db.Close()
 		return nil, err
 	}
-	log.Println("ClickHouse подключен")
-	return &ClickDB{db: db}, nil
+	return &ClickDB{db: db, writer: writer}, nil
 }
 
 func (l *ClickDB) LogAnalytics(invoiceID uint64, status, exchangerName string, duration float64, createdAt time.Time) error {
-	tx, err := l.db.Begin()
-	if err != nil {
-		log.Printf("Ошибка начала транзакции (analytics): %v", err)
-		return err
-	}
-
-	_, err = tx.Exec(`
-        INSERT INTO exchangers_analytics (invoice_id, status, exchanger_name, request_duration_ms, created_at, processed_at)
-        VALUES (?, ?, ?, ?, ?, ?)
-    `, invoiceID, status, exchangerName, duration, createdAt, time.Now())
-
-	if err != nil {
-		tx.Rollback()
-		log.Printf("Ошибка ClickHouse (analytics): %v", err)
-		return err
-	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Ошибка коммита (analytics): %v", err)
-		return err
-	}
-
-	log.Printf("ClickHouse: Записано в exchangers_analytics, invoice_id=%d, status=%s", invoiceID, status)
-	return nil
+	return l.writer.Write("exchangers_analytics", invoiceID, status, exchangerName, duration, createdAt, time.Now())
 }
 
 func (l *ClickDB) LogErrorApiRequests(invoiceID uint64, exchangerId uint32, errorMessage string) error {
-	tx, err := l.db.Begin()
-	if err != nil {
-		log.Printf("Ошибка начала транзакции (analytics): %v", err)
-		return err
-	}
-
-	_, err = tx.Exec(`
-        INSERT INTO api_error_requests (invoice_id, exchanger_id, error_message, time)
-        VALUES (?, ?, ?, ?)
-    `, invoiceID, exchangerId, errorMessage, time.Now())
+	return l.writer.Write("api_error_requests", invoiceID, exchangerId, redact.Default().Text(errorMessage), time.Now())
+}
 
-	if err != nil {
-		errRollback := tx.Rollback()
-		if errRollback != nil {
-			log.Printf("Не удалось выполнить rollback clickhouse (api_requests): %v", errRollback)
-		}
+func (l *ClickDB) LogErrorInvoice(invoice models.Invoice, errorMessage string) error {
+	return l.writer.Write("invoices_errors_logs", invoice.ID, redact.Default().Text(errorMessage), now())
+}
 
-		log.Printf("Ошибка ClickHouse (api_requests): %v", err)
-		return err
-	}
+func (l *ClickDB) ApiRequests(endpoint string, statusCode int, response string, params string, invoiceId uint64, exchangerId uint32) error {
+	return l.writer.Write("api_requests", invoiceId, exchangerId, statusCode,
+		redact.Default().URL("", endpoint), redact.Default().Params("", params), redact.Default().Body("", response), now())
+}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Ошибка коммита (api_requests): %v", err)
+// InvoiceHistoryInsert пишет смену статуса счета, at - время изменения в MySQL.
+// Пишется сразу, а не через очередь Writer: доставку и повторы обеспечивает invoice_outbox
+func (l *ClickDB) InvoiceHistoryInsert(invoiceId uint64, updatedBy string, status string, userId *uint64, details *string, at time.Time) error {
+	r := row{table: "invoice_history", values: []interface{}{invoiceId, status, updatedBy, deref(userId), deref(details), at.UTC().Format("2006-01-02 15:04:05")}}
+	if err := l.writer.insert(r.table, []row{r}); err != nil {
+		log.Printf("Ошибка ClickHouse (invoice_history): %v", err)
 		return err
 	}
 
-	log.Printf("ClickHouse: Записана ошибка для invoice_id=%d, exchanger=%s", invoiceID, exchangerId)
+	log.Printf("ClickHouse: записана история счета invoice_id=%d, status=%s", invoiceId, status)
 	return nil
 }
 
-func (l *ClickDB) LogErrorInvoice(invoice models.Invoice, errorMessage string) error {
-	tx, err := l.db.Begin()
-	if err != nil {
-		log.Printf("Ошибка начала транзакции (analytics): %v", err)
-		return err
-	}
+// LogBreakerState пишет смену состояния автомата защиты обменника
+func (l *ClickDB) LogBreakerState(exchangerID uint32, exchangerName, fromState, toState, reason string) error {
+	return l.writer.Write("exchangers_breaker_events", exchangerID, exchangerName, fromState, toState, reason, now())
+}
 
-	timeNow := time.Now().UTC().Format("2006-01-02 15:04:05")
+// LogOrderCancel пишет результат отмены заявки у обменника
+func (l *ClickDB) LogOrderCancel(invoiceID uint64, exchangerID uint32, externalID, reason, status, errorText string) error {
+	return l.writer.Write("exchangers_order_cancellations", invoiceID, exchangerID, externalID, reason, status, redact.Default().Text(errorText), now())
+}
 
-	_, err = tx.Exec(`
-        INSERT INTO invoices_errors_logs (invoice_id, error_message, time)
-        VALUES (?, ?, ?, ?)
-    `, invoice.ID, errorMessage, timeNow)
+// LogOrphanOrder пишет результат разбора заявки, потерянной при сбое
+func (l *ClickDB) LogOrphanOrder(invoiceID uint64, exchangerID uint32, externalID, source, action, details string) error {
+	return l.writer.Write("exchangers_orphan_orders", invoiceID, exchangerID, externalID, source, action, redact.Default().Body("", details), now())
+}
 
+// SuccessfulApiRequests возвращает пары (счет, обменник) с успешными запросами к API в окне [since, until)
+func (l *ClickDB) SuccessfulApiRequests(since, until time.Time) ([]models.ApiRequestPair, error) {
+	rows, err := l.db.Query(`
+        SELECT invoice_id, exchanger_id, any(endpoint), any(response)
+        FROM api_requests
+        WHERE time >= ? AND time < ? AND status_code IN (200, 201)
+        GROUP BY invoice_id, exchanger_id
+    `, since.UTC().Format("2006-01-02 15:04:05"), until.UTC().Format("2006-01-02 15:04:05"))
 	if err != nil {
-		errRollback := tx.Rollback()
-		if errRollback != nil {
-			log.Printf("Не удалось выполнить rollback clickhouse (api_requests): %v", errRollback)
-		}
-
-		log.Printf("Ошибка ClickHouse (api_requests): %v", err)
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Ошибка коммита (api_requests): %v", err)
-		return err
+	var pairs []models.ApiRequestPair
+	for rows.Next() {
+		var pair models.ApiRequestPair
+		if err := rows.Scan(&pair.InvoiceID, &pair.ExchangerID, &pair.Endpoint, &pair.Response); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
 	}
+	return pairs, rows.Err()
+}
 
-	log.Printf("ClickHouse: Записана ошибка для invoice_id=%d", invoice.ID)
-	return nil
+// LogRejectedTransition пишет переход статуса счета, запрещённый таблицей переходов
+func (l *ClickDB) LogRejectedTransition(invoiceID uint64, fromStatus, toStatus, updatedBy string, details *string) error {
+	return l.writer.Write("invoice_rejected_transitions", invoiceID, fromStatus, toStatus, updatedBy, details, now())
 }
 
-func (l *ClickDB) ApiRequests(endpoint string, statusCode int, response string, params string, invoiceId uint64, exchangerId uint32) error {
-	tx, err := l.db.Begin()
+// AnalyticsByExchanger возвращает статистику попыток по имени обменника начиная с since
+func (l *ClickDB) AnalyticsByExchanger(since time.Time) (map[string]models.ExchangerStats, error) {
+	rows, err := l.db.Query(`
+        SELECT exchanger_name, count(), countIf(status = 'success'), avg(request_duration_ms)
+        FROM exchangers_analytics
+        WHERE processed_at >= ?
+        GROUP BY exchanger_name
+    `, since)
 	if err != nil {
-		log.Printf("Ошибка начала транзакции (analytics): %v", err)
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	timeNow := time.Now().UTC().Format("2006-01-02 15:04:05")
-
-	_, err = tx.Exec(`
-        INSERT INTO api_requests (invoice_id, exchanger_id, status_code, endpoint, params, response, time)
-        VALUES (?, ?, ?, ?)
-    `, invoiceId, exchangerId, statusCode, endpoint, params, response, timeNow)
-
-	if err != nil {
-		errRollback := tx.Rollback()
-		if errRollback != nil {
-			log.Printf("Не удалось выполнить rollback clickhouse (api_requests): %v", errRollback)
+	stats := make(map[string]models.ExchangerStats)
+	for rows.Next() {
+		var name string
+		var s models.ExchangerStats
+		if err := rows.Scan(&name, &s.Attempts, &s.Successes, &s.AvgLatencyMs); err != nil {
+			return nil, err
 		}
-
-		log.Printf("Ошибка ClickHouse (api_requests): %v", err)
-		return err
+		stats[name] = s
 	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Ошибка коммита (api_requests): %v", err)
-		return err
-	}
-
-	log.Printf("ClickHouse: записан запрос к апи")
-	return nil
+	return stats, rows.Err()
 }
 
-func (l *ClickDB) InvoiceHistoryInsert(invoiceId uint64, updatedBy string, status string, userId *uint64, details *string) error {
-	tx, err := l.db.Begin()
-	if err != nil {
-		log.Printf("Ошибка начала транзакции (analytics): %v", err)
-		return err
-	}
-
-	timeNow := time.Now().UTC().Format("2006-01-02 15:04:05")
+// ApiCountsByExchanger возвращает число запросов и ошибок по ID обменника начиная с since
+func (l *ClickDB) ApiCountsByExchanger(since time.Time) (map[uint32]models.ExchangerApiCounts, error) {
+	counts := make(map[uint32]models.ExchangerApiCounts)
 
-	_, err = tx.Exec(`
-        INSERT INTO invoice_history (invoice_id, status, updated_by, user_id, details, time)
-        VALUES (?, ?, ?, ?)
-    `, invoiceId, status, updatedBy, userId, details, timeNow)
-
-	if err != nil {
-		errRollback := tx.Rollback()
-		if errRollback != nil {
-			log.Printf("Не удалось выполнить rollback clickhouse (invoice_history): %v", errRollback)
-		}
-
-		log.Printf("Ошибка ClickHouse (invoice_history): %v", err)
-		return err
+	queries := []struct {
+		query   string
+		isError bool
+	}{
+		{"SELECT exchanger_id, count() FROM api_requests WHERE time >= ? GROUP BY exchanger_id", false},
+		{"SELECT exchanger_id, count() FROM api_error_requests WHERE time >= ? GROUP BY exchanger_id", true},
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Ошибка коммита (invoice_history): %v", err)
-		return err
+	for _, q := range queries {
+		rows, err := l.db.Query(q.query, since.UTC().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var exchangerID uint32
+			var n uint64
+			if err := rows.Scan(&exchangerID, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			c := counts[exchangerID]
+			if q.isError {
+				c.Errors = n
+			} else {
+				c.Requests = n
+			}
+			counts[exchangerID] = c
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	log.Printf("ClickHouse: записан запрос к апи")
-	return nil
+	return counts, nil
 }
 
+// Close дописывает очередь Writer и закрывает соединение
 func (l *ClickDB) Close() {
+	if l.writer != nil {
+		l.writer.Close()
+	}
 	if l.db != nil {
 		l.db.Close()
 	}
 }
+
+// now - текущее время в формате DateTime ClickHouse
+func now() string {
+	return time.Now().UTC().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,110 @@
+package clickhouse
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"payment-service-go/migrate"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrate применяет встроенные миграции, которых нет в schema_migrations.
+// Транзакций для DDL в ClickHouse нет, поэтому миграции пишутся идемпотентными (IF NOT EXISTS)
+func (l *ClickDB) Migrate() error {
+	dir, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+	migrations, err := migrate.Load(dir)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version UInt32, name String, applied_at DateTime) ENGINE = MergeTree ORDER BY version")
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int]bool)
+	rows, err := l.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version uint32
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[int(version)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range migrate.Pending(migrations, applied) {
+		for _, statement := range m.Statements {
+			if _, err := l.db.Exec(statement); err != nil {
+				return fmt.Errorf("миграция ClickHouse %04d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		if err := l.markMigration(m); err != nil {
+			return err
+		}
+		log.Printf("ClickHouse: применена миграция %04d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+func (l *ClickDB) markMigration(m migrate.Migration) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", uint32(m.Version), m.Name, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// CheckSchema проверяет, что в ClickHouse есть все таблицы и колонки, в которые пишет пакет.
+// Возвращает *migrate.SchemaError со списком недостающих
+func (l *ClickDB) CheckSchema() error {
+	rows, err := l.db.Query("SELECT table, name FROM system.columns WHERE database = currentDatabase()")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	actual := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return err
+		}
+		if actual[table] == nil {
+			actual[table] = make(map[string]bool)
+		}
+		actual[table][column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if missing := migrate.Missing(tableColumns, actual); len(missing) > 0 {
+		return &migrate.SchemaError{Database: "ClickHouse", Missing: missing}
+	}
+	return nil
+}
+
+// Available - ClickHouse отвечает на ping. Пока он недоступен, миграции и проверка схемы пропускаются
+func (l *ClickDB) Available() bool {
+	return l.db.Ping() == nil
+}
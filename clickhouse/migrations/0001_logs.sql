@@ -0,0 +1,52 @@
+-- Журналы запросов к обменникам и истории счетов
+
+CREATE TABLE IF NOT EXISTS api_requests (
+    invoice_id UInt64,
+    exchanger_id UInt32,
+    status_code Int32,
+    endpoint String,
+    params String,
+    response String,
+    time DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (exchanger_id, time);
+
+CREATE TABLE IF NOT EXISTS api_error_requests (
+    invoice_id UInt64,
+    exchanger_id UInt32,
+    error_message String,
+    time DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (exchanger_id, time);
+
+CREATE TABLE IF NOT EXISTS invoices_errors_logs (
+    invoice_id UInt64,
+    error_message String,
+    time DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (invoice_id, time);
+
+CREATE TABLE IF NOT EXISTS invoice_history (
+    invoice_id UInt64,
+    status String,
+    updated_by String,
+    user_id Nullable(UInt64),
+    details Nullable(String),
+    time DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (invoice_id, time);
+
+CREATE TABLE IF NOT EXISTS exchangers_analytics (
+    invoice_id UInt64,
+    status String,
+    exchanger_name String,
+    request_duration_ms Float64,
+    created_at DateTime,
+    processed_at DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(processed_at)
+ORDER BY (exchanger_name, processed_at);
@@ -0,0 +1,12 @@
+-- Смены состояния автомата защиты обменника
+
+CREATE TABLE IF NOT EXISTS exchangers_breaker_events (
+    exchanger_id UInt32,
+    exchanger_name String,
+    from_state String,
+    to_state String,
+    reason String,
+    time DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (exchanger_id, time);
@@ -0,0 +1,12 @@
+-- Переходы статуса счета, запрещённые таблицей переходов
+
+CREATE TABLE IF NOT EXISTS invoice_rejected_transitions (
+    invoice_id UInt64,
+    from_status String,
+    to_status String,
+    updated_by String,
+    details Nullable(String),
+    time DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (invoice_id, time);
@@ -0,0 +1,13 @@
+-- Результаты отмены заявок у обменников
+
+CREATE TABLE IF NOT EXISTS exchangers_order_cancellations (
+    invoice_id UInt64,
+    exchanger_id UInt32,
+    external_id String,
+    reason String,
+    status String,
+    error String,
+    time DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (exchanger_id, time);
@@ -0,0 +1,13 @@
+-- Заявки, потерянные при сбое, и что с ними сделало восстановление
+
+CREATE TABLE IF NOT EXISTS exchangers_orphan_orders (
+    invoice_id UInt64,
+    exchanger_id UInt32,
+    external_id String,
+    source String,
+    action String,
+    details String,
+    time DateTime
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (exchanger_id, time);
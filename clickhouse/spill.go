@@ -0,0 +1,367 @@
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// spill - журнал строк, не записанных в ClickHouse: по строке JSON на запись.
+// Значения хранятся с типом, чтобы после чтения ClickHouse получил те же uint32/uint64/time.Time
+type spill struct {
+	path   string
+	replay sync.Mutex // занят, пока drain дописывает .replay в ClickHouse
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+type spillRow struct {
+	Table  string       `json:"table"`
+	Values []spillValue `json:"values"`
+}
+
+type spillValue struct {
+	Type  string          `json:"t"`
+	Value json.RawMessage `json:"v,omitempty"`
+}
+
+func openSpill(path string) (*spill, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &spill{path: path, file: file}, nil
+}
+
+// append дописывает строки в журнал и сбрасывает его на диск
+func (s *spill) append(rows []row) error {
+	var buf []byte
+	for _, r := range rows {
+		line, err := encodeRow(r)
+		if err != nil {
+			log.Printf("ClickHouse: строка %s не сохранена на диск: %v", r.table, err)
+			continue
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errors.New("журнал ClickHouse закрыт")
+	}
+	if _, err := s.file.Write(buf); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// drain передаёт строки журнала в write. Журнал переименовывается, чтобы новые строки
+// писались в свежий файл; то, что write вернул как незаписанное, остаётся в .replay до следующей попытки
+func (s *spill) drain(write func([]row) ([]row, error)) error {
+	s.replay.Lock()
+	defer s.replay.Unlock()
+	replayPath := s.path + ".replay"
+
+	if _, err := os.Stat(replayPath); errors.Is(err, os.ErrNotExist) {
+		s.mu.Lock()
+		if s.file == nil {
+			s.mu.Unlock()
+			return errors.New("журнал ClickHouse закрыт")
+		}
+		info, err := s.file.Stat()
+		if err != nil || info.Size() == 0 {
+			s.mu.Unlock()
+			return err
+		}
+		if err := s.rotate(replayPath); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.mu.Unlock()
+	}
+
+	rows, err := readSpill(replayPath)
+	if err != nil {
+		return err
+	}
+	left, writeErr := write(rows)
+	if writeErr == nil {
+		return os.Remove(replayPath)
+	}
+	if len(left) < len(rows) {
+		if err := rewriteSpill(replayPath, left); err != nil {
+			log.Printf("ClickHouse: не удалось обновить %s, часть строк будет записана повторно: %v", replayPath, err)
+		}
+	}
+	return writeErr
+}
+
+// trim удаляет самые старые строки, если журнал вместе с .replay больше limit. Удаляется до трёх четвертей limit,
+// чтобы не переписывать файл на каждой записи. Пока drain дописывает .replay, удаляются только строки журнала
+func (s *spill) trim(limit int64) (int, error) {
+	replayPath := s.path + ".replay"
+	withReplay := s.replay.TryLock()
+	if withReplay {
+		defer s.replay.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return 0, errors.New("журнал ClickHouse закрыт")
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	var replaySize int64
+	if replayInfo, err := os.Stat(replayPath); err == nil {
+		replaySize = replayInfo.Size()
+	}
+	if size+replaySize <= limit {
+		return 0, nil
+	}
+
+	excess := size + replaySize - limit*3/4
+	dropped := 0
+	if withReplay && replaySize > 0 {
+		n, freed, err := dropLines(replayPath, excess)
+		dropped += n
+		excess -= freed
+		if err != nil {
+			return dropped, err
+		}
+	}
+	if excess <= 0 {
+		return dropped, nil
+	}
+
+	// Журнал открыт на дозапись: закрываем, удаляем начало и открываем заново
+	if err := s.file.Close(); err != nil {
+		return dropped, err
+	}
+	n, _, dropErr := dropLines(s.path, excess)
+	dropped += n
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		s.file = nil
+		return dropped, err
+	}
+	s.file = file
+	return dropped, dropErr
+}
+
+// dropLines удаляет из начала файла целые строки, пока не освободит не меньше size байт.
+// Возвращает число удалённых строк и освобождённых байт
+func dropLines(path string, size int64) (int, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var freed int64
+	n := 0
+	for freed < size && freed < int64(len(data)) {
+		end := bytes.IndexByte(data[freed:], '\n')
+		if end < 0 {
+			freed = int64(len(data))
+		} else {
+			freed += int64(end) + 1
+		}
+		n++
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data[freed:], 0o600); err != nil {
+		return 0, 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, 0, err
+	}
+	return n, freed, nil
+}
+
+// rotate переименовывает журнал в path и открывает новый, вызывается под s.mu
+func (s *spill) rotate(path string) error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path, path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		s.file = nil
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+func (s *spill) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+func readSpill(path string) ([]row, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rows []row
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r, err := decodeRow(scanner.Bytes())
+		if err != nil {
+			// Битая строка, например недописанная при падении, - пропускаем
+			log.Printf("ClickHouse: пропущена повреждённая строка %s: %v", path, err)
+			continue
+		}
+		rows = append(rows, r)
+	}
+	return rows, scanner.Err()
+}
+
+// rewriteSpill атомарно заменяет файл строками rows
+func rewriteSpill(path string, rows []row) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, r := range rows {
+		line, err := encodeRow(r)
+		if err != nil {
+			continue
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func encodeRow(r row) ([]byte, error) {
+	sr := spillRow{Table: r.table, Values: make([]spillValue, len(r.values))}
+	for i, v := range r.values {
+		var typ string
+		var value interface{} = v
+		switch x := v.(type) {
+		case nil:
+			sr.Values[i] = spillValue{Type: "null"}
+			continue
+		case string:
+			typ = "string"
+		case int:
+			typ = "int"
+		case int64:
+			typ = "int64"
+		case uint32:
+			typ = "uint32"
+		case uint64:
+			typ = "uint64"
+		case float64:
+			typ = "float64"
+		case time.Time:
+			typ, value = "time", x.Format(time.RFC3339Nano)
+		default:
+			return nil, fmt.Errorf("неподдерживаемый тип %T", v)
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		sr.Values[i] = spillValue{Type: typ, Value: raw}
+	}
+	return json.Marshal(sr)
+}
+
+func decodeRow(line []byte) (row, error) {
+	var sr spillRow
+	if err := json.Unmarshal(line, &sr); err != nil {
+		return row{}, err
+	}
+	columns, ok := tableColumns[sr.Table]
+	if !ok || len(columns) != len(sr.Values) {
+		return row{}, fmt.Errorf("неизвестная таблица %s или число значений", sr.Table)
+	}
+
+	r := row{table: sr.Table, values: make([]interface{}, len(sr.Values))}
+	for i, sv := range sr.Values {
+		var err error
+		switch sv.Type {
+		case "null":
+			r.values[i] = nil
+		case "string":
+			var v string
+			err = json.Unmarshal(sv.Value, &v)
+			r.values[i] = v
+		case "int":
+			var v int
+			err = json.Unmarshal(sv.Value, &v)
+			r.values[i] = v
+		case "int64":
+			var v int64
+			err = json.Unmarshal(sv.Value, &v)
+			r.values[i] = v
+		case "uint32":
+			var v uint32
+			err = json.Unmarshal(sv.Value, &v)
+			r.values[i] = v
+		case "uint64":
+			var v uint64
+			err = json.Unmarshal(sv.Value, &v)
+			r.values[i] = v
+		case "float64":
+			var v float64
+			err = json.Unmarshal(sv.Value, &v)
+			r.values[i] = v
+		case "time":
+			var v string
+			if err = json.Unmarshal(sv.Value, &v); err == nil {
+				r.values[i], err = time.Parse(time.RFC3339Nano, v)
+			}
+		default:
+			err = fmt.Errorf("неизвестный тип %s", sv.Type)
+		}
+		if err != nil {
+			return row{}, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,431 @@
+package clickhouse
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	chdriver "github.com/ClickHouse/clickhouse-go"
+	"github.com/ClickHouse/clickhouse-go/lib/column"
+	"log"
+	"os"
+	"payment-service-go/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tableColumns - колонки таблиц, в которые пишет пакет. INSERT собирается из этого списка,
+// по нему же CheckSchema сверяет схему ClickHouse
+var tableColumns = map[string][]string{
+	"invoice_history":                {"invoice_id", "status", "updated_by", "user_id", "details", "time"},
+	"exchangers_analytics":           {"invoice_id", "status", "exchanger_name", "request_duration_ms", "created_at", "processed_at"},
+	"api_error_requests":             {"invoice_id", "exchanger_id", "error_message", "time"},
+	"invoices_errors_logs":           {"invoice_id", "error_message", "time"},
+	"api_requests":                   {"invoice_id", "exchanger_id", "status_code", "endpoint", "params", "response", "time"},
+	"exchangers_breaker_events":      {"exchanger_id", "exchanger_name", "from_state", "to_state", "reason", "time"},
+	"exchangers_order_cancellations": {"invoice_id", "exchanger_id", "external_id", "reason", "status", "error", "time"},
+	"exchangers_orphan_orders":       {"invoice_id", "exchanger_id", "external_id", "source", "action", "details", "time"},
+	"invoice_rejected_transitions":   {"invoice_id", "from_status", "to_status", "updated_by", "details", "time"},
+}
+
+// WriterConfig - параметры пакетной записи в ClickHouse
+type WriterConfig struct {
+	BatchSize      int           // строк одной таблицы в пакете
+	FlushInterval  time.Duration // максимальное время строки в памяти
+	QueueSize      int           // буфер очереди, при переполнении строки сразу уходят на диск
+	SpillPath      string        // файл, куда пишутся строки во время недоступности ClickHouse
+	SpillMaxBytes  int64         // предельный размер файла, при превышении удаляются самые старые строки
+	QuarantinePath string        // файл для строк, которые ClickHouse отверг как некорректные
+	ReplayInterval time.Duration // как часто пробовать дописать файл в ClickHouse
+}
+
+// LoadWriterConfig читает параметры записи из окружения
+func LoadWriterConfig() WriterConfig {
+	cfg := WriterConfig{
+		BatchSize:      envInt("CLICKHOUSE_BATCH_SIZE", 500),
+		FlushInterval:  envDuration("CLICKHOUSE_FLUSH_INTERVAL", 2*time.Second),
+		QueueSize:      envInt("CLICKHOUSE_QUEUE_SIZE", 10000),
+		SpillPath:      os.Getenv("CLICKHOUSE_SPILL_PATH"),
+		SpillMaxBytes:  int64(envInt("CLICKHOUSE_SPILL_MAX_MB", 512)) << 20,
+		QuarantinePath: os.Getenv("CLICKHOUSE_QUARANTINE_PATH"),
+		ReplayInterval: envDuration("CLICKHOUSE_REPLAY_INTERVAL", 30*time.Second),
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.SpillPath == "" {
+		cfg.SpillPath = "clickhouse-spill.wal"
+	}
+	if cfg.SpillMaxBytes <= 0 {
+		cfg.SpillMaxBytes = 512 << 20
+	}
+	if cfg.QuarantinePath == "" {
+		cfg.QuarantinePath = cfg.SpillPath + ".quarantine"
+	}
+	return cfg
+}
+
+type row struct {
+	table  string
+	values []interface{}
+}
+
+// Writer копит строки по таблицам и пишет их пакетами в фоне.
+// Пока ClickHouse недоступен, строки уходят в файл на диске и дописываются после восстановления.
+// Строки, которые ClickHouse отверг как некорректные, уходят в карантин и не блокируют остальные
+type Writer struct {
+	db         *sql.DB
+	config     WriterConfig
+	spill      *spill
+	quarantine *spill
+
+	mu      sync.RWMutex
+	closed  bool
+	rows    chan row
+	stop    chan struct{}
+	done    chan struct{}
+	healthy bool // только в горутине run
+}
+
+// NewWriter запускает фоновую запись. healthy=false - ClickHouse недоступен при старте
+func NewWriter(db *sql.DB, config WriterConfig, healthy bool) (*Writer, error) {
+	s, err := openSpill(config.SpillPath)
+	if err != nil {
+		return nil, err
+	}
+	q, err := openSpill(config.QuarantinePath)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	w := &Writer{
+		db:         db,
+		config:     config,
+		spill:      s,
+		quarantine: q,
+		rows:       make(chan row, config.QueueSize),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		healthy:    healthy,
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write ставит строку в очередь и не ждёт записи. Указатели разыменовываются сразу.
+// Ошибка возвращается, только если строку не удалось ни поставить в очередь, ни сохранить на диск
+func (w *Writer) Write(table string, values ...interface{}) error {
+	if len(values) != len(tableColumns[table]) {
+		return fmt.Errorf("clickhouse: таблица %s ожидает %d значений, передано %d", table, len(tableColumns[table]), len(values))
+	}
+	r := row{table: table, values: make([]interface{}, len(values))}
+	for i, v := range values {
+		r.values[i] = deref(v)
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return w.spillRows([]row{r})
+	}
+	select {
+	case w.rows <- r:
+		return nil
+	default:
+		log.Printf("ClickHouse: очередь записи переполнена, строка %s сохранена на диск", table)
+		return w.spillRows([]row{r})
+	}
+}
+
+// Close дописывает очередь и останавливает запись
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+	w.spill.close()
+	w.quarantine.close()
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	flushTicker := time.NewTicker(w.config.FlushInterval)
+	defer flushTicker.Stop()
+	replayTicker := time.NewTicker(w.config.ReplayInterval)
+	defer replayTicker.Stop()
+
+	pending := make(map[string][]row)
+	for {
+		select {
+		case r := <-w.rows:
+			pending[r.table] = append(pending[r.table], r)
+			if len(pending[r.table]) >= w.config.BatchSize {
+				w.flush(r.table, pending[r.table])
+				delete(pending, r.table)
+			}
+		case <-flushTicker.C:
+			w.flushAll(pending)
+		case <-replayTicker.C:
+			w.replay()
+		case <-w.stop:
+			// Новые строки в очередь уже не попадают, дописываем оставшиеся
+			for len(w.rows) > 0 {
+				r := <-w.rows
+				pending[r.table] = append(pending[r.table], r)
+			}
+			w.flushAll(pending)
+			return
+		}
+	}
+}
+
+func (w *Writer) flushAll(pending map[string][]row) {
+	for table, rows := range pending {
+		w.flush(table, rows)
+		delete(pending, table)
+	}
+}
+
+// flush пишет пакет одной таблицы. При ошибке соединения незаписанные строки уходят на диск,
+// и до успешного replay новые пакеты пишутся сразу туда
+func (w *Writer) flush(table string, rows []row) {
+	if len(rows) == 0 {
+		return
+	}
+	if w.healthy {
+		left, err := w.insertBatch(table, rows)
+		if err == nil {
+			log.Printf("ClickHouse: записано %d строк в %s", len(rows), table)
+			return
+		}
+		log.Printf("Ошибка ClickHouse (%s), запись переключена на диск: %v", table, err)
+		w.healthy = false
+		rows = left
+	}
+	if err := w.spillRows(rows); err != nil {
+		log.Printf("ClickHouse: потеряно %d строк %s, не удалось сохранить на диск: %v", len(rows), table, err)
+	}
+}
+
+// spillRows сохраняет строки на диск до восстановления ClickHouse. Если файл превысил SpillMaxBytes,
+// самые старые строки удаляются
+func (w *Writer) spillRows(rows []row) error {
+	if err := w.spill.append(rows); err != nil {
+		metrics.StoreWriteFailures.WithLabelValues(metrics.StoreClickHouse, "spill").Inc()
+		return err
+	}
+	metrics.ClickHouseSpilledRows.Add(float64(len(rows)))
+
+	dropped, err := w.spill.trim(w.config.SpillMaxBytes)
+	if err != nil {
+		log.Printf("ClickHouse: не удалось ограничить размер %s: %v", w.config.SpillPath, err)
+	}
+	if dropped > 0 {
+		log.Printf("ClickHouse: файл %s превысил %d байт, удалено %d самых старых строк", w.config.SpillPath, w.config.SpillMaxBytes, dropped)
+		metrics.ClickHouseDroppedRows.Add(float64(dropped))
+	}
+	return nil
+}
+
+// quarantineRow откладывает строку, которую ClickHouse отверг, в отдельный файл для ручного разбора
+func (w *Writer) quarantineRow(r row, cause error) {
+	log.Printf("ClickHouse: строка %s отвергнута и перенесена в %s: %v", r.table, w.config.QuarantinePath, cause)
+	if err := w.quarantine.append([]row{r}); err != nil {
+		log.Printf("ClickHouse: потеряна строка %s, не удалось сохранить в карантин: %v", r.table, err)
+		metrics.StoreWriteFailures.WithLabelValues(metrics.StoreClickHouse, "quarantine").Inc()
+		return
+	}
+	metrics.ClickHouseQuarantinedRows.Inc()
+}
+
+// replay дописывает сохранённые на диске строки. Повторная отправка пакета возможна,
+// если сервис упал между вставкой и перезаписью файла
+func (w *Writer) replay() {
+	if !w.healthy {
+		if err := w.db.Ping(); err != nil {
+			return
+		}
+	}
+
+	err := w.spill.drain(func(rows []row) ([]row, error) {
+		byTable := make(map[string][]row)
+		var order []string
+		for _, r := range rows {
+			if _, ok := byTable[r.table]; !ok {
+				order = append(order, r.table)
+			}
+			byTable[r.table] = append(byTable[r.table], r)
+		}
+
+		for i, table := range order {
+			tableRows := byTable[table]
+			for start := 0; start < len(tableRows); start += w.config.BatchSize {
+				end := min(start+w.config.BatchSize, len(tableRows))
+				if batchLeft, err := w.insertBatch(table, tableRows[start:end]); err != nil {
+					// Возвращаем всё, что не записано, для следующей попытки
+					left := append(append([]row{}, batchLeft...), tableRows[end:]...)
+					for _, rest := range order[i+1:] {
+						left = append(left, byTable[rest]...)
+					}
+					return left, err
+				}
+			}
+			log.Printf("ClickHouse: дописано с диска %d строк в %s", len(tableRows), table)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		log.Printf("ClickHouse: не удалось дописать строки с диска: %v", err)
+		w.healthy = false
+		return
+	}
+	if !w.healthy {
+		log.Println("ClickHouse: соединение восстановлено, запись переключена обратно")
+		w.healthy = true
+	}
+}
+
+// insertBatch пишет пакет. Если ClickHouse отверг данные пакета, строки пишутся по одной,
+// и отвергнутые уходят в карантин. Ошибка возвращается, только если ClickHouse недоступен,
+// вместе со строками, которые остались незаписанными
+func (w *Writer) insertBatch(table string, rows []row) ([]row, error) {
+	err := w.insert(table, rows)
+	if err == nil {
+		return nil, nil
+	}
+	if !isDataError(err) {
+		return rows, err
+	}
+
+	log.Printf("ClickHouse: пакет %s отвергнут, строки пишутся по одной: %v", table, err)
+	for i, r := range rows {
+		err := w.insert(table, []row{r})
+		if err == nil {
+			continue
+		}
+		if !isDataError(err) {
+			return rows[i:], err
+		}
+		w.quarantineRow(r, err)
+	}
+	return nil, nil
+}
+
+// unavailableCodes - коды исключений ClickHouse, при которых строки корректны, но записать их сейчас нельзя:
+// сеть, таймауты, перегрузка, права и расхождение схемы
+var unavailableCodes = map[int32]bool{
+	16:  true, // NO_SUCH_COLUMN_IN_TABLE
+	60:  true, // UNKNOWN_TABLE
+	81:  true, // UNKNOWN_DATABASE
+	159: true, // TIMEOUT_EXCEEDED
+	164: true, // READONLY
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+	497: true, // ACCESS_DENIED
+	516: true, // AUTHENTICATION_FAILED
+}
+
+// isDataError - ClickHouse отверг сами данные (тип, значение enum и т.п.), повтор той же строки не поможет
+func isDataError(err error) bool {
+	var exception *chdriver.Exception
+	if errors.As(err, &exception) {
+		return !unavailableCodes[exception.Code]
+	}
+	var typeErr *column.ErrUnexpectedType
+	return errors.As(err, &typeErr)
+}
+
+// insert пишет пакет одной транзакцией через подготовленный запрос, ошибка учитывается в метрике неудачных записей
+func (w *Writer) insert(table string, rows []row) error {
+	err := w.insertTx(table, rows)
+	if err != nil {
+		metrics.StoreWriteFailures.WithLabelValues(metrics.StoreClickHouse, table).Inc()
+	}
+	return err
+}
+
+func (w *Writer) insertTx(table string, rows []row) error {
+	columns := tableColumns[table]
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, r := range rows {
+		if _, err := stmt.Exec(r.values...); err != nil {
+			stmt.Close()
+			if errRollback := tx.Rollback(); errRollback != nil {
+				log.Printf("Не удалось выполнить rollback clickhouse (%s): %v", table, errRollback)
+			}
+			return err
+		}
+	}
+	stmt.Close()
+	return tx.Commit()
+}
+
+// deref разыменовывает указатели, чтобы строка не зависела от данных вызывающего
+func deref(v interface{}) interface{} {
+	switch p := v.(type) {
+	case *string:
+		if p == nil {
+			return nil
+		}
+		return *p
+	case *uint64:
+		if p == nil {
+			return nil
+		}
+		return *p
+	}
+	return v
+}
+
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%s: %v", key, value, err)
+		return def
+	}
+	return n
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Некорректное значение %s=%s", key, value)
+		return def
+	}
+	return d
+}
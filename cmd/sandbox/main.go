@@ -0,0 +1,57 @@
+// Песочница обменников для локального прогона сервиса без настоящих аккаунтов.
+// Endpoint обменника в service_exchangers/exchangers - http://<SANDBOX_ADDR>/<имя в нижнем регистре>
+package main
+
+import (
+	"context"
+	"github.com/joho/godotenv"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"payment-service-go/sandbox"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Файл .env не загружен: %v", err)
+	}
+
+	cfg, err := sandbox.LoadConfig()
+	if err != nil {
+		log.Fatalf("Ошибка сценариев песочницы: %v", err)
+	}
+
+	addr := os.Getenv("SANDBOX_ADDR")
+	if addr == "" {
+		addr = ":9090"
+	}
+
+	s := sandbox.New(cfg)
+	server := &http.Server{Addr: addr, Handler: s, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		for _, p := range sandbox.Providers {
+			log.Printf("Песочница %s: %s/%s", p, addr, strings.ToLower(p))
+		}
+		log.Printf("Песочница запущена на %s, оплата через %s, сценариев: %d", addr, cfg.PayAfter, len(cfg.Scenarios))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Ошибка HTTP-сервера песочницы: %v", err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	// Песочница закрывается первой, чтобы прервать запросы, зависшие по сценарию timeout
+	s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Ошибка остановки песочницы: %v", err)
+	}
+}
@@ -4,69 +4,116 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"payment-service-go/clickhouse"
+	"payment-service-go/metrics"
 	"payment-service-go/models"
-	"payment-service-go/mysql"
+	"payment-service-go/redact"
+	"sync"
 	"time"
 )
 
 type Processor struct {
-	MysqlLogger *mysql.MySQLDB
-	ClickLogger *clickhouse.ClickDB
+	MysqlLogger InvoiceStore
+	ClickLogger AuditLog
+	Events      EventPublisher
+	config      ProcessConfig
+	router      *Router
+	breakers    *Breakers
+	credentials *CredentialProvider
+	limits      *rateLimits
+	stop        chan struct{}
+	background  sync.WaitGroup // фоновые задачи, например дожидание гонки
 }
 
-// NewProcessor - конструктор
-func NewProcessor() *Processor {
-	mysqlLogger, err := mysql.NewMySQLDB()
-	if err != nil {
-		log.Fatalf("Ошибка MySQL logger: %v", err)
-	}
-	clickLogger, err := clickhouse.NewClickDB()
-	if err != nil {
-		log.Fatalf("Ошибка Clickhouse logger: %v", err)
+// NewProcessor - конструктор, запускает фоновые задачи. Хранилища переходят во владение процессора
+// и закрываются в Close, events нужен OutboxRelay с момента запуска
+func NewProcessor(mysqlLogger InvoiceStore, clickLogger AuditLog, events EventPublisher) (*Processor, error) {
+	if mysqlLogger == nil || clickLogger == nil || events == nil {
+		return nil, errors.New("процессору нужны InvoiceStore, AuditLog и EventPublisher")
 	}
 
-	return &Processor{
+	p := &Processor{
 		MysqlLogger: mysqlLogger,
 		ClickLogger: clickLogger,
+		Events:      events,
+		config:      LoadProcessConfig(),
+		credentials: NewCredentialProvider(mysqlLogger, envDuration("CREDENTIALS_TTL", 5*time.Minute)),
+		stop:        make(chan struct{}),
+	}
+	p.breakers = NewBreakers(LoadBreakerConfig(), func(exchangerID uint32, name string, from, to BreakerState, reason string) {
+		p.ClickLogger.LogBreakerState(exchangerID, name, string(from), string(to), reason)
+	})
+
+	polling := LoadPollConfig()
+	p.limits = newRateLimits(polling)
+
+	p.background.Add(1)
+	go p.runOutboxRelay()
+
+	if polling.Enabled {
+		poller := NewPoller(polling, p)
+		p.background.Add(1)
+		go func() {
+			defer p.background.Done()
+			poller.Run(p.stop)
+		}()
+	}
+
+	if recovery := LoadRecoveryConfig(); recovery.Enabled {
+		p.background.Add(1)
+		go p.runRecovery(recovery)
 	}
+
+	if routing := LoadRoutingConfig(); routing.Enabled {
+		p.router = NewRouter(routing, clickLogger)
+		// Роутер читает аналитику из ClickHouse, поэтому Close дожидается его до закрытия подключений
+		p.background.Add(1)
+		go func() {
+			defer p.background.Done()
+			p.router.Run(p.stop)
+		}()
+	}
+
+	return p, nil
 }
 
 // Process - обрабатывает задачу
 func (p *Processor) Process(task models.InvoiceTask) (string, error) {
+	task = p.resolveCredentials(task)
+	if p.router != nil {
+		task.Exchangers = p.router.Order(task)
+	}
+
+	if p.config.Mode == ModeRace {
+		return p.processRace(task)
+	}
+
 	// Перебираем обменники из задачи
 	for _, ex := range task.Exchangers {
-		// Создаём обменник на основе имени
-		var exchanger Exchanger
-		switch ex.Name {
-		case "Bitloga":
-			exchanger = NewBitlogaExchanger(ex, p) // Передаём всю структуру Exchanger
-			break
-		case "Greengo":
-			exchanger = NewGreengoExchanger(ex, p)
-			break
-		case "LuckyPay":
-			exchanger = NewLuckyPayExchanger(ex, p)
-			break
-		case "Racks":
-			exchanger = NewRacksExchanger(ex, p)
-			break
-		case "Test":
-			exchanger = NewTestExchanger(ex)
-			break
-		default:
-			log.Printf("Обменник %s не поддерживается", ex.Name)
+		// Создаём обменник через реестр
+		exchanger, err := p.newExchanger(ex, CapCreateOrder)
+		if err != nil {
+			log.Printf("Пропуск обменника: %v", err)
+			continue
+		}
+		if !p.breakers.Allow(ex) {
+			log.Printf("Пропуск обменника %s: автомат разомкнут", ex.Name)
 			continue
 		}
 
 		// Запрашиваем реквизиты
-		requisites, err := exchanger.GetRequisites(task, ex)
+		start := time.Now()
+		requisites, err := p.requestRequisites(task, ex, exchanger)
+		p.breakers.Record(ex, err)
 		if err == nil {
-			log.Printf("Реквизиты найдены через %s: %s", ex.Name, requisites.Requisites)
-			p.SuccessGetRequisites(task, ex, requisites)
+			p.recordAttempt(task, ex, attemptSuccess, time.Since(start))
+			log.Printf("Реквизиты найдены через %s: %s", ex.Name, redact.Default().Mask(requisites.Requisites))
+			if err := p.saveRequisites(task, ex, requisites); err != nil {
+				return "", err
+			}
 			return requisites.Requisites, nil
 		} else {
 			// Логируем ошибку в ClickHouse (api_requests)
+			p.recordAttempt(task, ex, attemptError, time.Since(start))
 			p.ClickLogger.LogErrorApiRequests(task.Invoice.ID, ex.ID, "Не удалось получить реквизиты: "+err.Error())
 			log.Printf("Ошибка в %s: %v", ex.Name, err)
 			continue
@@ -77,7 +124,10 @@ func (p *Processor) Process(task models.InvoiceTask) (string, error) {
 }
 
 func (p *Processor) ProcessInvoices() error {
-	now := time.Now().Format("2006-01-02 15:04:05")
+	start := time.Now()
+	defer func() { metrics.ProcessInvoicesDuration.Observe(time.Since(start).Seconds()) }()
+
+	now := start.Format("2006-01-02 15:04:05")
 	invoices, err := p.MysqlLogger.GetInvoicesByStatus("pending", now)
 
 	if err != nil {
@@ -99,52 +149,205 @@ func (p *Processor) ProcessInvoices() error {
 		grouped[key].Invoices = append(grouped[key].Invoices, models.InvoiceCheckLite{
 			ID:         inv.ID,
 			ExternalID: inv.ExternalID,
+			ExpiryAt:   inv.ExpiryAt,
 		})
 	}
+
+	var errs []error
 	for _, group := range grouped {
-		var exchanger Exchanger
-		switch group.Exchanger.Name {
-		case "Greengo":
-			exchanger = NewGreengoExchanger(group.Exchanger, p)
-			break
-		case "LuckyPay":
-			exchanger = NewLuckyPayExchanger(group.Exchanger, p)
-			break
-		default:
-			p.cancelInvoices(group.Invoices)
+		exchanger, err := p.newExchanger(group.Exchanger, CapCheckStatus)
+		if err != nil {
+			p.applyUncheckedPolicy(group)
+			continue
+		}
+		if !p.breakers.Allow(group.Exchanger) {
+			log.Printf("Проверка %d счетов %s отложена: автомат разомкнут", len(group.Invoices), group.Exchanger.Name)
+			continue
+		}
+		invoices := p.withinRateLimit(group, start)
+		if len(invoices) < len(group.Invoices) {
+			log.Printf("Проверка %d счетов %s отложена: лимит запросов исчерпан", len(group.Invoices)-len(invoices), group.Exchanger.Name)
+		}
+		if len(invoices) == 0 {
+			p.breakers.Release(group.Exchanger)
 			continue
 		}
 
-		err := exchanger.CheckInvoices(group.Invoices, group.ServiceID)
+		// Ошибка одного обменника не должна останавливать проверку остальных
+		err = exchanger.CheckInvoices(invoices, group.ServiceID)
+		if errors.Is(err, errRateLimited) {
+			log.Printf("Проверка счетов %s отложена: лимит запросов исчерпан", group.Exchanger.Name)
+			p.breakers.Release(group.Exchanger)
+			continue
+		}
+		p.breakers.Record(group.Exchanger, err)
+		countChecked(group.Exchanger.Name, "process_invoices", len(invoices), err)
 
 		if err != nil {
-			return fmt.Errorf("Не удалось проверить счета error: %v", err)
+			errs = append(errs, fmt.Errorf("%s: %v", group.Exchanger.Name, err))
 		}
 	}
 
+	if len(errs) > 0 {
+		return fmt.Errorf("Не удалось проверить счета error: %v", errors.Join(errs...))
+	}
 	return nil
 }
 
-func (p *Processor) cancelInvoices(invoices []models.InvoiceCheckLite) {
-	var IDs []uint64
+// withinRateLimit возвращает счета группы, на проверку которых хватает лимита запросов обменника:
+// один запрос на группу с CapBatchCheck, по запросу на счет без неё. Синхронизация с CapSyncCheck
+// расходует лимит сама
+func (p *Processor) withinRateLimit(group *models.ExchangerWithInvoices, now time.Time) []models.InvoiceCheckLite {
+	reg, _ := Lookup(group.Exchanger.Name)
+	switch {
+	case reg.Can(CapSyncCheck):
+		return group.Invoices
+	case reg.Can(CapBatchCheck):
+		if p.limits.take(group.Exchanger.Name, 1, now) == 0 {
+			return nil
+		}
+		return group.Invoices
+	default:
+		return group.Invoices[:p.limits.take(group.Exchanger.Name, len(group.Invoices), now)]
+	}
+}
+
+// applyUncheckedPolicy обрабатывает просроченные счета обменника, у которого нет API проверки статусов.
+// Пока не прошёл UncheckedGrace, счет ждёт callback
+func (p *Processor) applyUncheckedPolicy(group *models.ExchangerWithInvoices) {
+	if p.config.Unchecked == UncheckedKeep {
+		return
+	}
+
+	cutoff := time.Now().Add(-p.config.UncheckedGrace)
+	var due []models.InvoiceCheckLite
+	for _, inv := range group.Invoices {
+		if inv.ExpiryAt.Before(cutoff) {
+			due = append(due, inv)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	p.applyExpiryPolicy(group.Exchanger, due)
+	log.Printf("%s не проверяет статусы: %d счетов обработано по политике %s", group.Exchanger.Name, len(due), p.config.Unchecked)
+}
+
+// applyExpiryPolicy закрывает по UNCHECKED_POLICY просроченные счета, итоговый статус которых от обменника не получен
+func (p *Processor) applyExpiryPolicy(ex models.Exchanger, due []models.InvoiceCheckLite) {
+	switch p.config.Unchecked {
+	case UncheckedCancel:
+		for _, inv := range p.updateGroupStatus(due, models.StatusCancelTime, "golang_cancel_time") {
+			p.cancelOrder(ex, inv.ID, inv.ExternalID, string(models.StatusCancelTime))
+		}
+	case UncheckedError:
+		p.updateGroupStatus(due, models.StatusError, "golang_unchecked")
+	}
+}
 
+// updateGroupStatus массово меняет статус счетов, отклонённые переходы пишутся в ClickHouse.
+// Возвращает счета, к которым переход применён
+func (p *Processor) updateGroupStatus(invoices []models.InvoiceCheckLite, status models.InvoiceStatus, updatedBy string) []models.InvoiceCheckLite {
+	events := make([]models.InvoiceEvent, 0, len(invoices))
 	for _, inv := range invoices {
-		IDs = append(IDs, inv.ID)
+		event := newInvoiceEvent(models.StatusEventType(string(status)), inv.ID, string(status), updatedBy)
+		event.ExternalID = inv.ExternalID
+		events = append(events, event)
 	}
 
-	err := p.MysqlLogger.UpdateGrooupInvoicesStatus(IDs, "cancel_time")
+	rejected, err := p.MysqlLogger.UpdateGrooupInvoicesStatus(invoices, status, events)
 	if err != nil {
-		log.Printf("Не удалось отменить массово счета. Error: %v", err)
+		log.Printf("Не удалось массово обновить статус счетов на %s. Error: %v", status, err)
+		return nil
 	}
-	for _, invID := range IDs {
-		p.ClickLogger.InvoiceHistoryInsert(invID, "golang_cancel_time", "cancel_time", nil, nil)
+
+	skipped := make(map[uint64]bool, len(rejected))
+	for _, transitionErr := range rejected {
+		p.rejectTransition(transitionErr, updatedBy, nil)
+		skipped[transitionErr.InvoiceID] = true
 	}
+
+	applied := make([]models.InvoiceCheckLite, 0, len(invoices))
+	for _, inv := range invoices {
+		if !skipped[inv.ID] {
+			applied = append(applied, inv)
+			recordTransition(status, updatedBy, metrics.TransitionApplied)
+		}
+	}
+	return applied
 }
 
-func (p *Processor) SuccessGetRequisites(task models.InvoiceTask, exchangerTask models.Exchanger, details models.DetailsRequisites) error {
-	err := p.MysqlLogger.UpdateInvoice(task.Invoice.ID, exchangerTask.ID, details)
-	if err != nil {
-		return err
+// cancelOrder отменяет заявку у обменника, чтобы по её реквизитам больше не платили.
+// Результат пишется в exchangers_order_cancellations, false - заявка осталась открытой
+func (p *Processor) cancelOrder(ex models.Exchanger, invoiceID uint64, externalID, reason string) bool {
+	if externalID == "" {
+		return false
+	}
+
+	reg, ok := Lookup(ex.Name)
+	if !ok || !reg.Can(CapCancelOrder) {
+		p.ClickLogger.LogOrderCancel(invoiceID, ex.ID, externalID, reason, cancelUnsupported, "")
+		return false
+	}
+	canceller, ok := reg.Factory(ex, p).(OrderCanceller)
+	if !ok {
+		p.ClickLogger.LogOrderCancel(invoiceID, ex.ID, externalID, reason, cancelUnsupported, "")
+		return false
+	}
+
+	if err := canceller.CancelOrder(invoiceID, externalID); err != nil {
+		log.Printf("Не удалось отменить заявку %s в %s для счета %d: %v", externalID, ex.Name, invoiceID, err)
+		p.ClickLogger.LogOrderCancel(invoiceID, ex.ID, externalID, reason, cancelFailed, err.Error())
+		return false
+	}
+	if err := p.MysqlLogger.SetOrderAttemptState(invoiceID, ex.ID, models.OrderCancelled); err != nil {
+		log.Printf("Не удалось записать отмену заявки счета %d в журнал: %v", invoiceID, err)
+	}
+	log.Printf("Заявка %s в %s для счета %d отменена", externalID, ex.Name, invoiceID)
+	p.ClickLogger.LogOrderCancel(invoiceID, ex.ID, externalID, reason, cancelDone, "")
+	return true
+}
+
+// Close останавливает фоновые задачи и закрывает подключения к MySQL и ClickHouse
+func (p *Processor) Close() {
+	close(p.stop)
+	p.background.Wait()
+	p.MysqlLogger.Close()
+	p.ClickLogger.Close()
+	log.Println("Процессор остановлен")
+}
+
+// saveRequisites сохраняет полученные реквизиты. Ошибка записи возвращается, чтобы задача ушла на повтор,
+// отклонённый переход - нет: счет уже закрыт, заявка отменена в SuccessGetRequisites
+func (p *Processor) saveRequisites(task models.InvoiceTask, ex models.Exchanger, details models.DetailsRequisites) error {
+	err := p.SuccessGetRequisites(task, ex, details)
+	var transitionErr *models.TransitionError
+	if err != nil && !errors.As(err, &transitionErr) {
+		return fmt.Errorf("не удалось сохранить реквизиты счета %d: %w", task.Invoice.ID, err)
 	}
 	return nil
 }
+
+func (p *Processor) SuccessGetRequisites(task models.InvoiceTask, exchangerTask models.Exchanger, details models.DetailsRequisites) error {
+	event := newInvoiceEvent(models.EventRequisitesAssigned, task.Invoice.ID, string(models.StatusPending), "golang_get_requisites")
+	event.ExchangerID = exchangerTask.ID
+	event.ExternalID = details.ID
+	event.Data = map[string]interface{}{
+		"amount_in":  details.AmountIn,
+		"requisites": details.Requisites,
+		"until_at":   details.UntilAt,
+	}
+
+	err := p.MysqlLogger.UpdateInvoice(task.Invoice.ID, exchangerTask.ID, details, event)
+	if err == nil {
+		recordTransition(models.StatusPending, "golang_get_requisites", metrics.TransitionApplied)
+	}
+	var transitionErr *models.TransitionError
+	if errors.As(err, &transitionErr) {
+		// Счет уже закрыт, заявка у обменника не нужна
+		p.rejectTransition(transitionErr, "golang_get_requisites", nil)
+		p.cancelOrder(exchangerTask, task.Invoice.ID, details.ID, transitionErr.From)
+	}
+	return err
+}
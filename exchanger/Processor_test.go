@@ -0,0 +1,132 @@
+package exchanger
+
+import (
+	"payment-service-go/memory"
+	"payment-service-go/models"
+	"payment-service-go/sandbox"
+	"sync"
+	"testing"
+)
+
+const testServiceID = 1
+
+// recordingPublisher - EventPublisher, который запоминает события вместо публикации в RabbitMQ
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []models.InvoiceEvent
+}
+
+func (r *recordingPublisher) Publish(event models.InvoiceEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+// newTestProcessor создаёт процессор на хранилищах в памяти без опроса, восстановления и маршрутизации
+func newTestProcessor(t *testing.T) (*Processor, *memory.InvoiceStore, *memory.AuditLog) {
+	t.Helper()
+	t.Setenv("PROCESS_MODE", ModeSequential)
+	t.Setenv("POLL_ENABLED", "false")
+	t.Setenv("RECOVERY_ENABLED", "false")
+	t.Setenv("ROUTING_ENABLED", "false")
+
+	store := memory.NewInvoiceStore()
+	audit := memory.NewAuditLog()
+	p, err := NewProcessor(store, audit, &recordingPublisher{})
+	if err != nil {
+		t.Fatalf("NewProcessor: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p, store, audit
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    models.InvoiceStatus // статус счета до обработки
+		assigned  string               // external_id заявки, уже выданной по счету
+		scenarios []sandbox.Scenario
+		wantErr   bool
+		want      models.InvoiceStatus
+		rejected  int  // записей invoice_rejected_transitions
+		canceled  bool // заявка отменена у обменника
+	}{
+		{
+			name:   "реквизиты от первого обменника",
+			status: models.StatusSearch,
+			want:   models.StatusPending,
+		},
+		{
+			name:   "все обменники вернули ошибку",
+			status: models.StatusSearch,
+			scenarios: []sandbox.Scenario{
+				{Provider: sandbox.Bitloga, Operation: sandbox.OpCreate, StatusCode: 500},
+				{Provider: sandbox.Racks, Operation: sandbox.OpCreate, StatusCode: 500},
+			},
+			wantErr: true,
+			want:    models.StatusSearch,
+		},
+		{
+			name:     "счет закрыт до выдачи реквизитов",
+			status:   models.StatusCancelSearch,
+			want:     models.StatusCancelSearch,
+			rejected: 1,
+			canceled: true,
+		},
+		{
+			name:     "счет уже получил реквизиты другой заявки",
+			status:   models.StatusPending,
+			assigned: "other-order",
+			want:     models.StatusPending,
+			rejected: 1,
+			canceled: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := sandbox.NewTestServer(sandbox.Config{Credentials: map[string]sandbox.Credentials{
+				sandbox.Bitloga: {APIKey: "bitloga-key", SecretKey: "bitloga-secret"},
+				sandbox.Racks:   {APIKey: "racks-key", SecretKey: "racks-secret"},
+			}})
+			defer ts.Close()
+			for _, sc := range tt.scenarios {
+				ts.AddScenario(sc)
+			}
+
+			p, store, audit := newTestProcessor(t)
+			store.AddExchanger(testServiceID, ts.Exchanger(1, sandbox.Bitloga))
+			store.AddExchanger(testServiceID, ts.Exchanger(2, sandbox.Racks))
+			store.AddInvoice(memory.Invoice{ID: 10, ServiceID: testServiceID, Status: tt.status, ExchangerID: 9, ExternalID: tt.assigned})
+
+			task := models.InvoiceTask{
+				Invoice:    models.Invoice{ID: 10, ServiceID: testServiceID},
+				Exchangers: []models.Exchanger{{ID: 1, Amount: 1000}, {ID: 2, Amount: 1000}},
+			}
+			requisites, err := p.Process(task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && requisites == "" {
+				t.Error("Process() не вернул реквизиты")
+			}
+
+			invoice, _ := store.Invoice(10)
+			if invoice.Status != tt.want {
+				t.Errorf("статус счета = %q, want %q", invoice.Status, tt.want)
+			}
+
+			records := audit.Records()
+			if tt.wantErr && len(records.ApiErrors) != len(task.Exchangers) {
+				t.Errorf("ошибок api_error_requests = %d, want %d", len(records.ApiErrors), len(task.Exchangers))
+			}
+			if len(records.RejectedTransitions) != tt.rejected {
+				t.Errorf("отклонённых переходов = %d, want %d", len(records.RejectedTransitions), tt.rejected)
+			}
+			if canceled := len(records.OrderCancels) > 0; canceled != tt.canceled {
+				t.Errorf("заявка отменена = %v, want %v", canceled, tt.canceled)
+			}
+		})
+	}
+}
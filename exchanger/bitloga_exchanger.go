@@ -11,16 +11,24 @@ import (
 	"log"
 	"net/http"
 	"payment-service-go/models"
+	"payment-service-go/redact"
+	"strings"
 	"time"
 )
 
+func init() {
+	Register("Bitloga", CapCreateOrder|CapCheckStatus|CapCallback|CapCancelOrder, func(config models.Exchanger, processor *Processor) Exchanger {
+		return NewBitlogaExchanger(config, processor)
+	})
+}
+
 type BitlogaExchanger struct {
 	config    models.Exchanger
-	processor Processor
+	processor *Processor
 }
 
 func NewBitlogaExchanger(config models.Exchanger, processor *Processor) *BitlogaExchanger {
-	return &BitlogaExchanger{config: config, processor: *processor}
+	return &BitlogaExchanger{config: config, processor: processor}
 }
 
 func (g *BitlogaExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, serviceID uint64) error {
@@ -63,65 +71,210 @@ func (g *BitlogaExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, ser
 		}
 
 		if resp.StatusCode != 200 && resp.StatusCode != 201 {
-			return nil, body, errors.New("сервер вернул ошибку")
+			return nil, body, &StatusError{Code: resp.StatusCode, Body: string(body)}
 		}
 
 		return resp, body, nil
 	}
 
-	processor := NewProcessor()
-
+	// Ошибки по счетам копятся, чтобы автомат и метрики видели недоступность обменника
+	var errs []error
 	for _, invoice := range invoices {
 		bodyMap["uniqueid"] = invoice.ID
 		_, body, err := tryRequest()
 		if err != nil {
-			fmt.Errorf("[Bitloga] не удалось проверить счет InvoiceID: %v, error: %v", invoice.ID, err)
+			log.Printf("[Bitloga] не удалось проверить счет InvoiceID: %v, error: %v", invoice.ID, err)
+			errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
 			continue
 		}
 		var result map[string]interface{}
 		if err := json.Unmarshal(body, &result); err != nil {
-			fmt.Errorf("[Bitloga] не удалось проверить счет InvoiceID: %v, error: %v", invoice.ID, err)
+			log.Printf("[Bitloga] не удалось проверить счет InvoiceID: %v, error: %v", invoice.ID, err)
+			errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
 			continue
 		}
 
 		status, ok := result["status"].(string)
 		if !ok {
-			fmt.Errorf("[Bitloga] не удалось получить статус у InvoiceID: %v, error: %v", invoice.ID, err)
+			log.Printf("[Bitloga] не удалось получить статус у InvoiceID: %v", invoice.ID)
+			errs = append(errs, fmt.Errorf("счет %d: не удалось получить 'status'", invoice.ID))
 			continue
 		}
 
-		err = g.processStatusInvoice(processor, invoice, status)
+		err = g.processor.applyOrderStatus(invoice, status, g.mapStatus)
 
 		if err != nil {
-			fmt.Errorf("[Bitloga] не удалось обрабатотать статус у InvoiceID: %v, error: %v", invoice.ID, err)
+			log.Printf("[Bitloga] не удалось обрабатотать статус у InvoiceID: %v, error: %v", invoice.ID, err)
+			// Отклонённый переход уже записан и не говорит о сбое обменника
+			var transitionErr *models.TransitionError
+			if !errors.As(err, &transitionErr) {
+				errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
+			}
 			continue
 		}
 	}
 
+	if len(errs) > 0 {
+		return fmt.Errorf("[Bitloga] %w", errors.Join(errs...))
+	}
 	return nil
 }
 
-func (g *BitlogaExchanger) processStatusInvoice(processor *Processor, invoice models.InvoiceCheckLite, orderStatus string) error {
+// mapStatus переводит статус заявки Bitloga в статус счета, пустая строка - статус не меняется
+func (g *BitlogaExchanger) mapStatus(orderStatus string) (models.InvoiceStatus, error) {
 	switch orderStatus {
 	case "Payed":
-		err := processor.MysqlLogger.UpdateInvoiceStatus(invoice, "paid")
-		if err != nil {
-			return err
-		}
+		return models.StatusPaid, nil
 	case "Pending":
-		return nil
+		return "", nil
 	case "Error":
-		err := processor.MysqlLogger.UpdateInvoiceStatus(invoice, "error")
-		if err != nil {
-			return err
-		}
+		return models.StatusError, nil
 	case "Canceled":
-		err := processor.MysqlLogger.UpdateInvoiceStatus(invoice, "cancel_time")
-		if err != nil {
-			return err
-		}
+		return models.StatusCancelTime, nil
 	default:
-		return errors.New("Не получилось обработать статус")
+		return "", errors.New("Не получилось обработать статус")
+	}
+}
+
+// FindOrder ищет ожидающую оплаты заявку по uniqueid = ID счета
+func (g *BitlogaExchanger) FindOrder(invoiceID uint64) (models.DetailsRequisites, bool, error) {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"action":   "details",
+		"uniqueid": invoiceID,
+	})
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+
+	req, err := http.NewRequest("POST", g.config.Endpoint+"/api/v1/order/", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+
+	h := hmac.New(sha512.New, []byte(g.config.SecretKey))
+	h.Write(reqBody)
+	signature := fmt.Sprintf("%x", h.Sum(nil))
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-APIKEY", g.config.APIKey)
+	req.Header.Set("X-SIGNATURE", signature)
+
+	client := &http.Client{Timeout: 8 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return models.DetailsRequisites{}, false, nil
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return models.DetailsRequisites{}, false, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+
+	// Только заявка в ожидании оплаты может быть выдана повторно
+	if status, _ := result["status"].(string); status != "Pending" {
+		return models.DetailsRequisites{}, false, nil
+	}
+
+	details, err := g.ReturnFormattedDetails(result)
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+	return details, true, nil
+}
+
+// CancelOrder отменяет заявку Bitloga
+func (g *BitlogaExchanger) CancelOrder(invoiceID uint64, externalID string) error {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"action":    "cancel",
+		"invoiceid": externalID,
+	})
+	if err != nil {
+		return err
+	}
+
+	urlApi := g.config.Endpoint + "/api/v1/"
+	req, err := http.NewRequest("POST", urlApi, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+
+	h := hmac.New(sha512.New, []byte(g.config.SecretKey))
+	h.Write(reqBody)
+	signature := fmt.Sprintf("%x", h.Sum(nil))
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-APIKEY", g.config.APIKey)
+	req.Header.Set("X-SIGNATURE", signature)
+
+	client := &http.Client{Timeout: 8 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	g.processor.logApiRequest(g.config, urlApi, resp.StatusCode, string(body), string(reqBody), invoiceID)
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	if result["success"] != true {
+		msg, _ := result["message"].(string)
+		return fmt.Errorf("[Bitloga] заявка не отменена: %s", msg)
+	}
+	return nil
+}
+
+// ParseCallback разбирает уведомление Bitloga: invoiceid - ID заявки, status - статус
+func (g *BitlogaExchanger) ParseCallback(body []byte) (string, string, error) {
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", "", err
+	}
+
+	externalID, ok := result["invoiceid"].(string)
+	if !ok || externalID == "" {
+		return "", "", errors.New("'invoiceid' is empty")
+	}
+	status, ok := result["status"].(string)
+	if !ok {
+		return "", "", errors.New("'status' не строка")
+	}
+	return externalID, status, nil
+}
+
+// VerifyCallback сверяет X-SIGNATURE с HMAC-SHA512 тела на SecretKey, как в запросах к Bitloga
+func (g *BitlogaExchanger) VerifyCallback(header http.Header, body []byte) error {
+	h := hmac.New(sha512.New, []byte(g.config.SecretKey))
+	h.Write(body)
+	expected := fmt.Sprintf("%x", h.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(header.Get("X-SIGNATURE")))) {
+		return errors.New("X-SIGNATURE не совпадает")
 	}
 	return nil
 }
@@ -142,10 +295,10 @@ func (g *BitlogaExchanger) GetRequisites(task models.InvoiceTask, ex models.Exch
 
 	reqBody, err := json.Marshal(data)
 	if err != nil {
-		fmt.Println("Ошибка сериализации JSON:", err)
+		log.Printf("[Bitloga] ошибка сериализации JSON: %v", err)
 		return models.DetailsRequisites{}, err
 	}
-	fmt.Println("JSON тело:", string(reqBody))
+	log.Printf("[Bitloga] тело запроса: %s", redact.Default().Body(ex.Name, string(reqBody)))
 
 	urlApi := ex.Endpoint + "/api/v1/"
 	req, err := http.NewRequest("POST", urlApi, bytes.NewBuffer(reqBody))
@@ -174,16 +327,15 @@ func (g *BitlogaExchanger) GetRequisites(task models.InvoiceTask, ex models.Exch
 	}
 
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return models.DetailsRequisites{}, errors.New("сервер вернул ошибку: " + string(body))
+		return models.DetailsRequisites{}, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
-	g.processor.ClickLogger.ApiRequests(urlApi, resp.StatusCode, string(body), string(reqBody), task.Invoice.ID, ex.ID)
+	g.processor.logApiRequest(ex, urlApi, resp.StatusCode, string(body), string(reqBody), task.Invoice.ID)
 
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
 		return models.DetailsRequisites{}, err
 	}
-	log.Printf("result: %v", result)
 
 	if result["success"] != true {
 		var msg string
@@ -0,0 +1,196 @@
+package exchanger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"payment-service-go/models"
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// StatusError - обменник ответил кодом, отличным от 200/201
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return "сервер вернул ошибку"
+	}
+	return "сервер вернул ошибку: " + e.Body
+}
+
+// isBreakerFailure - ошибка говорит о недоступности обменника (5xx, сеть, таймаут),
+// а не об отказе в выдаче реквизитов
+func isBreakerFailure(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// BreakerConfig - пороги автоматов защиты
+type BreakerConfig struct {
+	FailureThreshold int           // подряд идущих отказов до размыкания, 0 - автоматы выключены
+	OpenTimeout      time.Duration // сколько обменник пропускается перед пробным запросом
+	HalfOpenProbes   int           // одновременных пробных запросов в half_open
+}
+
+// LoadBreakerConfig читает настройки из окружения
+func LoadBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: envInt("BREAKER_FAILURE_THRESHOLD", 5),
+		OpenTimeout:      envDuration("BREAKER_OPEN_TIMEOUT", 30*time.Second),
+		HalfOpenProbes:   envInt("BREAKER_HALF_OPEN_PROBES", 1),
+	}
+}
+
+type breaker struct {
+	name     string
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+// Breakers - автоматы защиты по ID обменника
+type Breakers struct {
+	config   BreakerConfig
+	onChange func(exchangerID uint32, name string, from, to BreakerState, reason string)
+
+	mu    sync.Mutex
+	items map[uint32]*breaker
+}
+
+func NewBreakers(config BreakerConfig, onChange func(exchangerID uint32, name string, from, to BreakerState, reason string)) *Breakers {
+	return &Breakers{config: config, onChange: onChange, items: make(map[uint32]*breaker)}
+}
+
+// Allow сообщает, можно ли отправить запрос обменнику.
+// В half_open пропускает ограниченное число пробных запросов, каждый из них обязан закончиться Record
+// или, если запрос так и не отправлен, Release
+func (b *Breakers) Allow(ex models.Exchanger) bool {
+	if b.config.FailureThreshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(ex)
+	switch br.state {
+	case BreakerOpen:
+		if time.Since(br.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.transition(ex.ID, br, BreakerHalfOpen, "истёк таймаут размыкания")
+		fallthrough
+	case BreakerHalfOpen:
+		if br.probes >= b.config.HalfOpenProbes {
+			return false
+		}
+		br.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// Record учитывает результат запроса к обменнику
+func (b *Breakers) Record(ex models.Exchanger, err error) {
+	if b.config.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(ex)
+	if br.state == BreakerHalfOpen && br.probes > 0 {
+		br.probes--
+	}
+
+	if err == nil || !isBreakerFailure(err) {
+		br.failures = 0
+		if br.state == BreakerHalfOpen {
+			b.transition(ex.ID, br, BreakerClosed, "пробный запрос успешен")
+		}
+		return
+	}
+
+	br.failures++
+	switch br.state {
+	case BreakerHalfOpen:
+		br.openedAt = time.Now()
+		b.transition(ex.ID, br, BreakerOpen, "пробный запрос неуспешен: "+err.Error())
+	case BreakerClosed:
+		if br.failures >= b.config.FailureThreshold {
+			br.openedAt = time.Now()
+			b.transition(ex.ID, br, BreakerOpen, fmt.Sprintf("%d отказов подряд: %v", br.failures, err))
+		}
+	}
+}
+
+// Release возвращает пробный запрос, который не был отправлен, например из-за лимита запросов.
+// Результат не учитывается
+func (b *Breakers) Release(ex models.Exchanger) {
+	if b.config.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(ex)
+	if br.state == BreakerHalfOpen && br.probes > 0 {
+		br.probes--
+	}
+}
+
+// IsOpen - обменник сейчас пропускается. Пробные запросы не расходует
+func (b *Breakers) IsOpen(ex models.Exchanger) bool {
+	if b.config.FailureThreshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.items[ex.ID]
+	return ok && br.state == BreakerOpen && time.Since(br.openedAt) < b.config.OpenTimeout
+}
+
+func (b *Breakers) get(ex models.Exchanger) *breaker {
+	br, ok := b.items[ex.ID]
+	if !ok {
+		br = &breaker{name: ex.Name, state: BreakerClosed}
+		b.items[ex.ID] = br
+	}
+	return br
+}
+
+func (b *Breakers) transition(exchangerID uint32, br *breaker, to BreakerState, reason string) {
+	from := br.state
+	br.state = to
+	if to != BreakerHalfOpen {
+		br.probes = 0
+	}
+	log.Printf("Автомат обменника %s (%d): %s -> %s, %s", br.name, exchangerID, from, to, reason)
+	if b.onChange != nil {
+		// Запись в ClickHouse не должна держать мьютекс автоматов
+		go b.onChange(exchangerID, br.name, from, to, reason)
+	}
+}
@@ -0,0 +1,77 @@
+package exchanger
+
+import (
+	"payment-service-go/models"
+	"testing"
+	"time"
+)
+
+// checkStub - обменник с пакетной проверкой статусов, который считает вызовы CheckInvoices
+type checkStub struct {
+	*TestExchanger
+	calls *int
+	err   error
+}
+
+func (c *checkStub) CheckInvoices(invoices []models.InvoiceCheckLite, serviceID uint64) error {
+	*c.calls++
+	return c.err
+}
+
+var stubCalls int
+var stubErr error
+
+func init() {
+	Register("BreakerStub", CapCheckStatus|CapBatchCheck, func(config models.Exchanger, _ *Processor) Exchanger {
+		return &checkStub{TestExchanger: NewTestExchanger(config), calls: &stubCalls, err: stubErr}
+	})
+}
+
+// halfOpenBreakers возвращает автоматы, у которых автомат обменника ex переходит в half_open при следующем Allow
+func halfOpenBreakers(ex models.Exchanger) *Breakers {
+	breakers := NewBreakers(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 1}, nil)
+	breakers.Record(ex, &StatusError{Code: 500})
+	breakers.get(ex).openedAt = time.Now().Add(-time.Hour)
+	return breakers
+}
+
+func TestBreakersHalfOpenRateLimitedSkip(t *testing.T) {
+	ex := models.Exchanger{ID: 7, Name: "BreakerStub"}
+	invoices := []models.InvoiceCheckLite{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	tests := []struct {
+		name      string
+		tokens    int   // токенов в лимите обменника
+		err       error // ответ пробного вызова
+		wantCalls int
+		wantState BreakerState
+	}{
+		{name: "лимит исчерпан - проба возвращается", tokens: 0, wantCalls: 0, wantState: BreakerHalfOpen},
+		{name: "пробный вызов успешен - пачки идут дальше", tokens: 3, wantCalls: 3, wantState: BreakerClosed},
+		{name: "пробный вызов неуспешен - один вызов", tokens: 3, err: &StatusError{Code: 502}, wantCalls: 1, wantState: BreakerOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, _ := newTestProcessor(t)
+			p.breakers = halfOpenBreakers(ex)
+			p.limits = newRateLimits(PollConfig{RateLimits: map[string]int{"*": 12}})
+			now := time.Now()
+			p.limits.take(ex.Name, 3-tt.tokens, now)
+			stubCalls, stubErr = 0, tt.err
+
+			poller := NewPoller(PollConfig{BatchSize: 1}, p)
+			poller.check(&models.ExchangerWithInvoices{ServiceID: testServiceID, Exchanger: ex, Invoices: invoices}, now)
+
+			if stubCalls != tt.wantCalls {
+				t.Errorf("вызовов CheckInvoices = %d, want %d", stubCalls, tt.wantCalls)
+			}
+			if state := p.breakers.get(ex).state; state != tt.wantState {
+				t.Errorf("состояние автомата = %s, want %s", state, tt.wantState)
+			}
+			if tt.wantState == BreakerHalfOpen && !p.breakers.Allow(ex) {
+				t.Error("проба не возвращена: Allow в half_open отказал")
+			}
+		})
+	}
+}
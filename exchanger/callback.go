@@ -0,0 +1,98 @@
+package exchanger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"payment-service-go/models"
+)
+
+var (
+	// ErrCallbackSignature - подпись уведомления не совпала
+	ErrCallbackSignature = errors.New("неверная подпись уведомления")
+	// ErrCallbackInvoiceNotFound - по уведомлению не найден счет
+	ErrCallbackInvoiceNotFound = errors.New("счет по уведомлению не найден")
+	// ErrCallbackPayload - уведомление не удалось разобрать
+	ErrCallbackPayload = errors.New("некорректное уведомление")
+)
+
+// CallbackReceivers возвращает имена обменников, принимающих уведомления
+func CallbackReceivers() []string {
+	var names []string
+	for _, name := range Registered() {
+		reg, _ := Lookup(name)
+		if !reg.Can(CapCallback) {
+			continue
+		}
+		if _, ok := reg.Factory(models.Exchanger{Name: name}, nil).(CallbackReceiver); !ok {
+			log.Printf("Обменник %s объявляет callback, но не принимает уведомления", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// HandleCallback проверяет уведомление обменника и применяет статус заявки к счету.
+// Подпись проверяется до поиска счета ключами всех сервисов обменника, чтобы без ключа нельзя было узнать,
+// какие заявки существуют. Счет должен принадлежать сервису, ключ которого подошёл.
+// Повторное уведомление с тем же статусом ничего не меняет, запрещённый переход фиксируется и не считается ошибкой
+func (p *Processor) HandleCallback(name string, header http.Header, body []byte) error {
+	reg, ok := Lookup(name)
+	if !ok || !reg.Can(CapCallback) {
+		return fmt.Errorf("обменник %s не принимает уведомления", name)
+	}
+	if _, ok := reg.Factory(models.Exchanger{Name: name}, p).(CallbackReceiver); !ok {
+		return fmt.Errorf("обменник %s не принимает уведомления", name)
+	}
+
+	credentials, err := p.MysqlLogger.GetCallbackCredentials(name)
+	if err != nil {
+		return err
+	}
+	var receiver CallbackReceiver
+	verified := make(map[uint64]bool)
+	for serviceID, ex := range credentials {
+		candidate := reg.Factory(ex, p).(CallbackReceiver)
+		if candidate.VerifyCallback(header, body) != nil {
+			continue
+		}
+		verified[serviceID] = true
+		receiver = candidate
+	}
+	// Счет до проверки подписи не известен, отказ логирует обработчик уведомлений
+	if receiver == nil {
+		return ErrCallbackSignature
+	}
+
+	externalID, orderStatus, err := receiver.ParseCallback(body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCallbackPayload, err)
+	}
+
+	invoice, err := p.MysqlLogger.GetCallbackInvoice(name, externalID)
+	if err != nil {
+		return err
+	}
+	if invoice == nil || !verified[invoice.ServiceID] {
+		return ErrCallbackInvoiceNotFound
+	}
+
+	status, err := receiver.mapStatus(orderStatus)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCallbackPayload, orderStatus, err)
+	}
+	if status == models.StatusSearch {
+		return nil
+	}
+
+	details := "Callback OrderStatus: " + orderStatus
+	lite := models.InvoiceCheckLite{ID: invoice.ID, ExternalID: invoice.ExternalID}
+	err = p.ChangeInvoiceStatus(lite, status, "golang_callback", &details)
+	var transitionErr *models.TransitionError
+	if errors.As(err, &transitionErr) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,212 @@
+package exchanger
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ModeSequential = "sequential" // обменники опрашиваются по очереди
+	ModeRace       = "race"       // обменники опрашиваются параллельно, берётся первый ответ
+)
+
+// Политика для просроченных счетов, итоговый статус которых обменник не сообщил: у обменника нет
+// API проверки статусов или синхронизация LuckyPay прошла срок счета без статуса заявки
+const (
+	UncheckedCancel = "cancel" // отменить по таймауту
+	UncheckedError  = "error"  // перевести в error для ручного разбора
+	UncheckedKeep   = "keep"   // оставить в pending, статус придёт через callback
+)
+
+// ProcessConfig - настройки поиска реквизитов
+type ProcessConfig struct {
+	Mode          string
+	RaceWidth     int           // сколько обменников опрашивать одновременно, 0 - все
+	LatencyBudget time.Duration // общий лимит времени на поиск реквизитов по задаче, только в режиме race
+
+	Unchecked      string        // политика для обменников без CapCheckStatus и заявок LuckyPay без итогового статуса
+	UncheckedGrace time.Duration // сколько ждать callback после истечения счета до применения политики
+}
+
+// LoadProcessConfig читает настройки из окружения
+func LoadProcessConfig() ProcessConfig {
+	cfg := ProcessConfig{
+		Mode:           envString("PROCESS_MODE", ModeSequential),
+		RaceWidth:      envInt("PROCESS_RACE_WIDTH", 0),
+		LatencyBudget:  envDuration("PROCESS_LATENCY_BUDGET", 30*time.Second),
+		Unchecked:      envString("UNCHECKED_POLICY", UncheckedError),
+		UncheckedGrace: envDuration("UNCHECKED_GRACE", 30*time.Minute),
+	}
+	if cfg.Mode != ModeSequential && cfg.Mode != ModeRace {
+		log.Printf("Неизвестный PROCESS_MODE=%s, используется %s", cfg.Mode, ModeSequential)
+		cfg.Mode = ModeSequential
+	}
+	switch cfg.Unchecked {
+	case UncheckedCancel, UncheckedError, UncheckedKeep:
+	default:
+		log.Printf("Неизвестный UNCHECKED_POLICY=%s, используется %s", cfg.Unchecked, UncheckedError)
+		cfg.Unchecked = UncheckedError
+	}
+	return cfg
+}
+
+// RoutingConfig - настройки маршрутизации
+type RoutingConfig struct {
+	Enabled bool
+	Window  time.Duration // окно истории для расчёта оценки
+	Refresh time.Duration // период обновления статистики
+	// Weights - статические веса: ключ "serviceID:exchangerID", "*" вместо serviceID - для всех сервисов
+	Weights map[string]float64
+}
+
+// LoadRoutingConfig читает настройки из окружения.
+// ROUTING_WEIGHTS задаётся как "12:3=2.5,*:4=0.5"
+func LoadRoutingConfig() RoutingConfig {
+	cfg := RoutingConfig{
+		Enabled: envString("ROUTING_ENABLED", "false") == "true",
+		Window:  envDuration("ROUTING_WINDOW", time.Hour),
+		Refresh: envDuration("ROUTING_REFRESH", time.Minute),
+		Weights: make(map[string]float64),
+	}
+
+	for _, pair := range strings.Split(envString("ROUTING_WEIGHTS", ""), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !strings.Contains(parts[0], ":") {
+			log.Printf("Некорректный вес маршрутизации: %s", pair)
+			continue
+		}
+		weight, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			log.Printf("Некорректный вес маршрутизации: %s", pair)
+			continue
+		}
+		cfg.Weights[strings.TrimSpace(parts[0])] = weight
+	}
+
+	return cfg
+}
+
+func (c RoutingConfig) weight(serviceID uint64, exchangerID uint32) float64 {
+	exID := strconv.FormatUint(uint64(exchangerID), 10)
+	if w, ok := c.Weights[strconv.FormatUint(serviceID, 10)+":"+exID]; ok && serviceID > 0 {
+		return w
+	}
+	if w, ok := c.Weights["*:"+exID]; ok {
+		return w
+	}
+	return 1
+}
+
+// PollConfig - настройки опроса статусов счетов в течение срока их жизни
+type PollConfig struct {
+	Enabled    bool
+	Tick       time.Duration // как часто искать счета, которые пора проверить
+	Fast       time.Duration // интервал сразу после создания и перед истечением
+	Slow       time.Duration // интервал в середине срока жизни
+	FastWindow time.Duration // сколько после создания и до истечения действует Fast
+	BatchSize  int           // счетов в одном запросе для обменников с CapBatchCheck
+	// RateLimits - запросов в минуту по имени обменника, "*" - для остальных
+	RateLimits map[string]int
+}
+
+// LoadPollConfig читает настройки из окружения.
+// POLL_RATE_LIMITS задаётся как "Greengo=120,*=60"
+func LoadPollConfig() PollConfig {
+	cfg := PollConfig{
+		Enabled:    envString("POLL_ENABLED", "true") == "true",
+		Tick:       envDuration("POLL_TICK", 10*time.Second),
+		Fast:       envDuration("POLL_FAST_INTERVAL", 30*time.Second),
+		Slow:       envDuration("POLL_SLOW_INTERVAL", 2*time.Minute),
+		FastWindow: envDuration("POLL_FAST_WINDOW", 5*time.Minute),
+		BatchSize:  envInt("POLL_BATCH_SIZE", 50),
+		RateLimits: map[string]int{"*": 60},
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 50
+	}
+
+	for _, pair := range strings.Split(envString("POLL_RATE_LIMITS", ""), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			log.Printf("Некорректный лимит опроса: %s", pair)
+			continue
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || limit <= 0 {
+			log.Printf("Некорректный лимит опроса: %s", pair)
+			continue
+		}
+		cfg.RateLimits[strings.TrimSpace(parts[0])] = limit
+	}
+
+	return cfg
+}
+
+func (c PollConfig) rateLimit(name string) int {
+	if limit, ok := c.RateLimits[name]; ok {
+		return limit
+	}
+	return c.RateLimits["*"]
+}
+
+// RecoveryConfig - настройки поиска заявок, потерянных при сбое
+type RecoveryConfig struct {
+	Enabled  bool
+	Interval time.Duration // период запуска после стартового прохода
+	Grace    time.Duration // записи моложе считаются ещё в обработке
+	Lookback time.Duration // глубина поиска
+}
+
+// LoadRecoveryConfig читает настройки из окружения
+func LoadRecoveryConfig() RecoveryConfig {
+	return RecoveryConfig{
+		Enabled:  envString("RECOVERY_ENABLED", "true") == "true",
+		Interval: envDuration("RECOVERY_INTERVAL", 5*time.Minute),
+		Grace:    envDuration("RECOVERY_GRACE", 2*time.Minute),
+		Lookback: envDuration("RECOVERY_LOOKBACK", 24*time.Hour),
+	}
+}
+
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%s: %v", key, value, err)
+		return def
+	}
+	return n
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%s: %v", key, value, err)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,108 @@
+package exchanger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"payment-service-go/models"
+	"sync"
+	"time"
+)
+
+type credentialKey struct {
+	serviceID   uint64
+	exchangerID uint32
+}
+
+type credentialEntry struct {
+	exchanger models.Exchanger
+	expiresAt time.Time
+}
+
+// CredentialProvider подставляет имя, адрес и ключи обменника из service_exchangers/exchangers.
+// Ответы кешируются на ttl, запись сбрасывается при отказе обменника в авторизации
+type CredentialProvider struct {
+	db  InvoiceStore
+	ttl time.Duration
+
+	mu    sync.RWMutex
+	cache map[credentialKey]credentialEntry
+}
+
+func NewCredentialProvider(db InvoiceStore, ttl time.Duration) *CredentialProvider {
+	return &CredentialProvider{db: db, ttl: ttl, cache: make(map[credentialKey]credentialEntry)}
+}
+
+// Resolve возвращает обменник с ключами для сервиса
+func (c *CredentialProvider) Resolve(serviceID uint64, exchangerID uint32) (models.Exchanger, error) {
+	key := credentialKey{serviceID: serviceID, exchangerID: exchangerID}
+
+	c.mu.RLock()
+	entry, ok := c.cache[key]
+	c.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.exchanger, nil
+	}
+
+	ex, err := c.db.GetExchangerCredentials(serviceID, exchangerID)
+	if err != nil {
+		return models.Exchanger{}, err
+	}
+	if ex == nil {
+		return models.Exchanger{}, fmt.Errorf("обменник %d не подключен к сервису %d", exchangerID, serviceID)
+	}
+
+	c.mu.Lock()
+	c.cache[key] = credentialEntry{exchanger: *ex, expiresAt: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return *ex, nil
+}
+
+// Invalidate сбрасывает ключи обменника сервиса, следующий Resolve прочитает их из MySQL
+func (c *CredentialProvider) Invalidate(serviceID uint64, exchangerID uint32) {
+	c.mu.Lock()
+	delete(c.cache, credentialKey{serviceID: serviceID, exchangerID: exchangerID})
+	c.mu.Unlock()
+}
+
+// InvalidateAll сбрасывает весь кеш, например после ротации ключей
+func (c *CredentialProvider) InvalidateAll() {
+	c.mu.Lock()
+	c.cache = make(map[credentialKey]credentialEntry)
+	c.mu.Unlock()
+}
+
+// invalidateOnAuthError сбрасывает ключи, если обменник отказал в авторизации - возможно, их сменили
+func (c *CredentialProvider) invalidateOnAuthError(serviceID uint64, exchangerID uint32, err error) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && (statusErr.Code == http.StatusUnauthorized || statusErr.Code == http.StatusForbidden) {
+		c.Invalidate(serviceID, exchangerID)
+	}
+}
+
+// resolveCredentials подставляет ключи в обменники задачи нового формата.
+// Обменники, ключи которых не удалось получить, из задачи исключаются
+func (p *Processor) resolveCredentials(task models.InvoiceTask) models.InvoiceTask {
+	exchangers := make([]models.Exchanger, 0, len(task.Exchangers))
+	for _, ex := range task.Exchangers {
+		if ex.HasCredentials() {
+			// Старый формат сообщения, ключи переданы продюсером
+			exchangers = append(exchangers, ex)
+			continue
+		}
+
+		resolved, err := p.credentials.Resolve(task.Invoice.ServiceID, ex.ID)
+		if err != nil {
+			log.Printf("Пропуск обменника %d для счета %d: %v", ex.ID, task.Invoice.ID, err)
+			continue
+		}
+		ex.Name = resolved.Name
+		ex.Endpoint = resolved.Endpoint
+		ex.APIKey = resolved.APIKey
+		ex.SecretKey = resolved.SecretKey
+		exchangers = append(exchangers, ex)
+	}
+	task.Exchangers = exchangers
+	return task
+}
@@ -1,9 +1,33 @@
 package exchanger
 
-import "payment-service-go/models"
+import (
+	"net/http"
+	"payment-service-go/models"
+)
 
 type Exchanger interface {
 	GetRequisites(task models.InvoiceTask, ex models.Exchanger) (models.DetailsRequisites, error)
 	ReturnFormattedDetails(data map[string]interface{}) (models.DetailsRequisites, error)
 	CheckInvoices(invoices []models.InvoiceCheckLite, serviceID uint64) error
 }
+
+// OrderFinder - обменник, у которого заявку можно найти по ID нашего счета
+// (LuckyPay - client_order_id, Bitloga - uniqueid). found=false - заявки нет
+type OrderFinder interface {
+	FindOrder(invoiceID uint64) (details models.DetailsRequisites, found bool, err error)
+}
+
+// OrderCanceller - обменник с CapCancelOrder, который отменяет заявку, чтобы по реквизитам больше не платили
+type OrderCanceller interface {
+	CancelOrder(invoiceID uint64, externalID string) error
+}
+
+// CallbackReceiver - обменник с CapCallback, который принимает уведомления о статусе заявки
+type CallbackReceiver interface {
+	// ParseCallback достаёт из уведомления внешний ID заявки и статус обменника
+	ParseCallback(body []byte) (externalID, orderStatus string, err error)
+	// VerifyCallback проверяет подпись уведомления ключами обменника
+	VerifyCallback(header http.Header, body []byte) error
+
+	mapStatus(orderStatus string) (models.InvoiceStatus, error)
+}
@@ -13,6 +13,12 @@ import (
 	"time"
 )
 
+func init() {
+	Register("Greengo", CapCreateOrder|CapCheckStatus|CapBatchCheck|CapCancelOrder, func(config models.Exchanger, processor *Processor) Exchanger {
+		return NewGreengoExchanger(config, processor)
+	})
+}
+
 type GreengoExchanger struct {
 	config    models.Exchanger
 	processor *Processor
@@ -63,11 +69,11 @@ func (g *GreengoExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, ser
 	}
 
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return errors.New("[Greengo] сервер вернул ошибку: " + string(body))
+		return fmt.Errorf("[Greengo] %w", &StatusError{Code: resp.StatusCode, Body: string(body)})
 	}
 
 	for _, invId := range invoices {
-		g.processor.ClickLogger.ApiRequests(urlApi, resp.StatusCode, string(body), string(reqBody), invId.ID, g.config.ID)
+		g.processor.logApiRequest(g.config, urlApi, resp.StatusCode, string(body), string(reqBody), invId.ID)
 	}
 
 	var result map[string]interface{}
@@ -112,7 +118,7 @@ func (g *GreengoExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, ser
 			continue
 		}
 
-		err = g.processedOrderStatus(*invoiceByExternalID, statusOrder)
+		err = g.processor.applyOrderStatus(*invoiceByExternalID, statusOrder, g.mapStatus)
 		if err != nil {
 			log.Printf("[Greengo] не удалось обработать статус у ExternalOrderID: %v, error: %v", externalOrderId, err)
 			continue
@@ -123,29 +129,72 @@ func (g *GreengoExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, ser
 	return nil
 }
 
-func (g *GreengoExchanger) processedOrderStatus(invoice models.InvoiceCheckLite, orderStatus string) error {
+// mapStatus переводит статус заявки Greengo в статус счета, пустая строка - статус не меняется
+func (g *GreengoExchanger) mapStatus(orderStatus string) (models.InvoiceStatus, error) {
 	switch orderStatus {
 	case "payed":
-		err := g.processor.MysqlLogger.UpdateInvoiceStatus(invoice, "pending_confirm")
-		if err != nil {
-			return err
-		}
+		return models.StatusPendingConfirm, nil
 	case "completed":
-		err := g.processor.MysqlLogger.UpdateInvoiceStatus(invoice, "paid")
-		if err != nil {
-			return err
-		}
+		return models.StatusPaid, nil
 	case "unconfirmed":
-		return nil
+		return "", nil
 	case "awaiting":
-		return nil
+		return "", nil
 	case "autocanceled":
-		err := g.processor.MysqlLogger.UpdateInvoiceStatus(invoice, "cancel_time")
-		if err != nil {
-			return err
-		}
+		return models.StatusCancelTime, nil
 	default:
-		return errors.New("Не получилось обработать статус")
+		return "", errors.New("Не получилось обработать статус")
+	}
+}
+
+// CancelOrder отменяет заявку Greengo
+func (g *GreengoExchanger) CancelOrder(invoiceID uint64, externalID string) error {
+	orderID, err := strconv.ParseInt(externalID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("[Greengo] некорректный order_id %q", externalID)
+	}
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"order_id": orderID,
+	})
+	if err != nil {
+		return err
+	}
+
+	urlApi := g.config.Endpoint + "/api/v2/order/cancel/"
+
+	req, err := http.NewRequest("POST", urlApi, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Api-Secret", g.config.APIKey)
+
+	client := &http.Client{Timeout: 8 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return fmt.Errorf("[Greengo] %w", &StatusError{Code: resp.StatusCode, Body: string(body)})
+	}
+
+	g.processor.logApiRequest(g.config, urlApi, resp.StatusCode, string(body), string(reqBody), invoiceID)
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	if result["response"] != "success" {
+		return fmt.Errorf("[Greengo] заявка не отменена: %v", result["response"])
 	}
 	return nil
 }
@@ -185,7 +234,7 @@ func (g *GreengoExchanger) GetRequisites(task models.InvoiceTask, ex models.Exch
 	}
 
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return models.DetailsRequisites{}, errors.New("сервер вернул ошибку: " + string(body))
+		return models.DetailsRequisites{}, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
 	var result map[string]interface{}
@@ -197,7 +246,7 @@ func (g *GreengoExchanger) GetRequisites(task models.InvoiceTask, ex models.Exch
 		return models.DetailsRequisites{}, errors.New(result["response"].(string))
 	}
 
-	g.processor.ClickLogger.ApiRequests(urlApi, resp.StatusCode, string(body), string(reqBody), task.Invoice.ID, ex.ID)
+	g.processor.logApiRequest(ex, urlApi, resp.StatusCode, string(body), string(reqBody), task.Invoice.ID)
 
 	// Проверка, что items — слайс и не пустой
 	itemsRaw, ok := result["items"].([]interface{})
@@ -0,0 +1,102 @@
+package exchanger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"payment-service-go/models"
+	"time"
+)
+
+// orderReuseMargin - минимальный остаток срока, при котором сохранённые реквизиты ещё можно выдать
+const orderReuseMargin = 2 * time.Minute
+
+// errOrderUncertain - прошлый запрос на создание заявки не завершился, а найти заявку у обменника нельзя
+var errOrderUncertain = errors.New("результат прошлого создания заявки неизвестен")
+
+// requestRequisites получает реквизиты через журнал invoice_exchanger_attempts.
+// При повторной доставке задачи выдаёт уже созданную живую заявку или ищет её у обменника,
+// чтобы не создавать вторую заявку на тот же счет
+func (p *Processor) requestRequisites(task models.InvoiceTask, ex models.Exchanger, exchanger Exchanger) (models.DetailsRequisites, error) {
+	attempt, err := p.MysqlLogger.GetOrderAttempt(task.Invoice.ID, ex.ID)
+	if err != nil {
+		// Без журнала дубликат не исключить, поэтому обменник пропускается
+		return models.DetailsRequisites{}, fmt.Errorf("журнал заявок недоступен: %v", err)
+	}
+
+	if attempt != nil {
+		switch attempt.State {
+		case models.OrderCreated:
+			if attempt.Details != nil && orderLive(*attempt.Details, attempt.UpdatedAt, time.Now()) {
+				log.Printf("Счет %d: повторно выдана заявка %s в %s", task.Invoice.ID, attempt.ExternalID, ex.Name)
+				return *attempt.Details, nil
+			}
+		case models.OrderCreating:
+			finder, ok := exchanger.(OrderFinder)
+			if !ok {
+				return models.DetailsRequisites{}, errOrderUncertain
+			}
+			details, found, err := finder.FindOrder(task.Invoice.ID)
+			if err != nil {
+				return models.DetailsRequisites{}, fmt.Errorf("не удалось найти заявку у обменника: %v", err)
+			}
+			if found {
+				log.Printf("Счет %d: найдена ранее созданная заявка %s в %s", task.Invoice.ID, details.ID, ex.Name)
+				p.saveAttempt(task, ex, models.OrderCreated, &details, "")
+				return details, nil
+			}
+		}
+	}
+
+	// Без записи creating упавший после создания заявки процесс не узнает о ней, поэтому заявка не создаётся
+	if err := p.saveAttempt(task, ex, models.OrderCreating, nil, ""); err != nil {
+		return models.DetailsRequisites{}, fmt.Errorf("журнал заявок недоступен: %v", err)
+	}
+	start := time.Now()
+	details, err := exchanger.GetRequisites(task, ex)
+	observeRequisites(ex, start, err)
+	if err != nil {
+		p.credentials.invalidateOnAuthError(task.Invoice.ServiceID, ex.ID, err)
+		// При сетевой ошибке заявка могла создаться, запись остаётся в creating
+		var netErr net.Error
+		if !errors.As(err, &netErr) {
+			p.saveAttempt(task, ex, models.OrderFailed, nil, err.Error())
+		}
+		return models.DetailsRequisites{}, err
+	}
+
+	p.saveAttempt(task, ex, models.OrderCreated, &details, "")
+	return details, nil
+}
+
+// saveAttempt пишет состояние заявки в журнал. Ошибка логируется и возвращается, но важна только для creating:
+// остальные состояния пишутся после ответа обменника, и незаписанная заявка находится через FindOrder
+func (p *Processor) saveAttempt(task models.InvoiceTask, ex models.Exchanger, state string, details *models.DetailsRequisites, errorText string) error {
+	attempt := models.OrderAttempt{
+		InvoiceID:   task.Invoice.ID,
+		ExchangerID: ex.ID,
+		State:       state,
+		Details:     details,
+		Error:       errorText,
+	}
+	if details != nil {
+		attempt.ExternalID = details.ID
+	}
+	err := p.MysqlLogger.SaveOrderAttempt(attempt)
+	if err != nil {
+		log.Printf("Не удалось записать журнал заявки счета %d в %s: %v", task.Invoice.ID, ex.Name, err)
+	}
+	return err
+}
+
+// orderLive сообщает, действуют ли ещё реквизиты заявки. UntilAt хранится в UTC.
+// Если срок в ответе обменника не распознан, считается 20 минут от создания
+func orderLive(details models.DetailsRequisites, createdAt, now time.Time) bool {
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339} {
+		if until, err := time.Parse(layout, details.UntilAt); err == nil {
+			return until.Sub(now) > orderReuseMargin
+		}
+	}
+	return createdAt.Add(20*time.Minute).Sub(now) > orderReuseMargin
+}
@@ -0,0 +1,53 @@
+package exchanger
+
+import (
+	"payment-service-go/models"
+	"testing"
+	"time"
+)
+
+func TestOrderLiveNonUTC(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+3", 3*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	now := time.Now()
+	racks := NewRacksExchanger(models.Exchanger{Name: "Racks"}, nil)
+
+	tests := []struct {
+		name  string
+		until time.Time // срок заявки у обменника
+		want  bool
+	}{
+		{name: "действует ещё 10 минут", until: now.Add(10 * time.Minute), want: true},
+		{name: "истекает через минуту", until: now.Add(time.Minute), want: false},
+		{name: "истекла минуту назад", until: now.Add(-time.Minute), want: false},
+		{name: "истекла два часа назад", until: now.Add(-2 * time.Hour), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := racks.ReturnFormattedDetails(map[string]interface{}{
+				"msg_error": "",
+				"order": []interface{}{map[string]interface{}{
+					"id":        "order-1",
+					"cart":      "2200 0000 0000 0000",
+					"amount":    "1000",
+					"time_unix": float64(tt.until.Unix()),
+				}},
+			})
+			if err != nil {
+				t.Fatalf("ReturnFormattedDetails: %v", err)
+			}
+			if got := orderLive(details, now, now); got != tt.want {
+				t.Errorf("orderLive(until_at=%s) = %v, want %v", details.UntilAt, got, tt.want)
+			}
+		})
+	}
+
+	// Без срока в ответе считается 20 минут от записи журнала, которую драйвер MySQL читает в UTC
+	written := now.Add(-30 * time.Minute).UTC()
+	if orderLive(models.DetailsRequisites{}, written, now) {
+		t.Error("заявка, записанная в журнал 30 минут назад, считается действующей")
+	}
+}
@@ -0,0 +1,12 @@
+package exchanger
+
+import (
+	"payment-service-go/models"
+	"payment-service-go/redact"
+)
+
+// logApiRequest пишет запрос к обменнику в api_requests, секреты и реквизиты маскируются правилами обменника
+func (p *Processor) logApiRequest(ex models.Exchanger, urlApi string, statusCode int, response, params string, invoiceID uint64) {
+	r := redact.Default()
+	p.ClickLogger.ApiRequests(r.URL(ex.Name, urlApi), statusCode, r.Body(ex.Name, response), r.Params(ex.Name, params), invoiceID, ex.ID)
+}
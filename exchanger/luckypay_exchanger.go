@@ -8,11 +8,25 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"payment-service-go/models"
 	"strconv"
 	"time"
 )
 
+func init() {
+	Register("LuckyPay", CapCreateOrder|CapCheckStatus|CapSyncCheck|CapCancelOrder, func(config models.Exchanger, processor *Processor) Exchanger {
+		return NewLuckyPayExchanger(config, processor)
+	})
+}
+
+const (
+	luckyPayPageSize     = 100
+	luckyPayMaxPages     = 100
+	luckyPaySyncLookback = time.Hour   // окно первой синхронизации, пока нет отметки
+	luckyPaySyncOverlap  = time.Minute // перекрытие соседних окон
+)
+
 type LuckyPayExchanger struct {
 	config    models.Exchanger
 	processor *Processor
@@ -25,125 +39,325 @@ func NewLuckyPayExchanger(config models.Exchanger, processor *Processor) *LuckyP
 	}
 }
 
+// CheckInvoices синхронизирует статусы заявок LuckyPay за окно от отметки синхронизации до текущего момента.
+// Обрабатываются только заявки, ожидающие оплаты у нас. Каждая страница расходует запрос из лимита обменника.
+// Окно, страницы которого не умещаются в luckyPayMaxPages или в остаток лимита, сужается, и отметка сдвигается
+// на конец пройденного окна - остаток догоняется следующими проходами
 func (l *LuckyPayExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, serviceID uint64) error {
-	var externalIDs []int64
-
-	for _, inv := range invoices {
-		id, err := strconv.ParseInt(inv.ExternalID, 10, 64)
-		if err == nil {
-			externalIDs = append(externalIDs, id)
+	pending, err := l.processor.MysqlLogger.GetPendingInvoicesByExchanger(serviceID, l.config.ID)
+	if err != nil {
+		return err
+	}
+	byExternalID := make(map[string]models.InvoiceCheckLite, len(pending)+len(invoices))
+	for _, inv := range append(pending, invoices...) {
+		if inv.ExternalID != "" {
+			byExternalID[inv.ExternalID] = inv
 		}
 	}
 
-	reqBody, err := json.Marshal(map[string]interface{}{
-		"page":         1,
-		"size":         100,
-		"order_side":   "buy",
-		"order_status": "Completed,CanceledByTimeout,CanceledByService",
-	})
-
+	now := time.Now().UTC()
+	to := now
+	from := to.Add(-luckyPaySyncLookback)
+	mark, ok, err := l.processor.MysqlLogger.GetSyncMark(serviceID, l.config.ID)
 	if err != nil {
 		return err
 	}
+	if ok {
+		// Перекрытие окон защищает от заявок, обновлённых в момент прошлого запроса
+		from = mark.Add(-luckyPaySyncOverlap)
+	}
+
+	if len(byExternalID) == 0 {
+		return l.processor.MysqlLogger.SaveSyncMark(serviceID, l.config.ID, to)
+	}
+
+window:
+	for {
+		if l.processor.limits.take(l.config.Name, 1, time.Now()) == 0 {
+			return errRateLimited
+		}
+		items, pages, err := l.fetchOrders(1, from, to)
+		if err != nil {
+			return err
+		}
+
+		limit := min(luckyPayMaxPages, 1+l.processor.limits.available(l.config.Name, time.Now()))
+		if pages > limit {
+			if to, err = narrowSyncWindow(from, to, limit, pages); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for page := 1; ; page++ {
+			if page > 1 {
+				if page > limit {
+					// Число страниц LuckyPay не сообщил, а предел достигнут - проходим половину окна заново
+					if to, err = narrowSyncWindow(from, to, 1, 2); err != nil {
+						return err
+					}
+					continue window
+				}
+				if l.processor.limits.take(l.config.Name, 1, time.Now()) == 0 {
+					return errRateLimited
+				}
+				if items, pages, err = l.fetchOrders(page, from, to); err != nil {
+					return err
+				}
+			}
+
+			l.applyOrders(items, byExternalID)
+			if len(items) < luckyPayPageSize || (pages > 0 && page >= pages) {
+				break window
+			}
+		}
+	}
+
+	if to.Before(now) {
+		log.Printf("[LuckyPay] окно синхронизации сужено до %s, остаток - на следующем проходе", to.Format(time.RFC3339))
+	}
+	l.expireUnsynced(byExternalID, to)
+	return l.processor.MysqlLogger.SaveSyncMark(serviceID, l.config.ID, to)
+}
+
+// expireUnsynced применяет политику UNCHECKED_POLICY к счетам, которые истекли больше UncheckedGrace назад
+// по отметке синхронизации to, а итоговый статус заявки в пройденных окнах так и не пришёл.
+// Следующие окна начинаются после отметки, и без политики такой счет остался бы в pending навсегда
+func (l *LuckyPayExchanger) expireUnsynced(byExternalID map[string]models.InvoiceCheckLite, to time.Time) {
+	if l.processor.config.Unchecked == UncheckedKeep {
+		return
+	}
+
+	cutoff := to.Add(-l.processor.config.UncheckedGrace)
+	var due []models.InvoiceCheckLite
+	for _, inv := range byExternalID {
+		if !inv.ExpiryAt.IsZero() && inv.ExpiryAt.Before(cutoff) {
+			due = append(due, inv)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	l.processor.applyExpiryPolicy(l.config, due)
+	log.Printf("[LuckyPay] итоговый статус не получен: %d просроченных счетов обработано по политике %s", len(due), l.processor.config.Unchecked)
+}
+
+// applyOrders применяет статусы заявок страницы к нашим ожидающим счетам. Обработанный счет убирается из
+// byExternalID, чтобы при повторном проходе суженного окна не применять статус ещё раз
+func (l *LuckyPayExchanger) applyOrders(items []interface{}, byExternalID map[string]models.InvoiceCheckLite) {
+	for _, orderItem := range items {
+		orderItemData, ok := orderItem.(map[string]interface{})
+		if !ok {
+			log.Println("[LuckyPay] не удалось получить информацию о orderItemData")
+			continue
+		}
+
+		id, ok := orderItemData["id"].(string)
+		if !ok {
+			log.Println("[LuckyPay] не удалось получить 'id'")
+			continue
+		}
+
+		invoice, ok := byExternalID[id]
+		if !ok {
+			continue
+		}
+
+		status, ok := orderItemData["status"].(string)
+		if !ok {
+			log.Printf("[LuckyPay] не удалось получить 'status' у ExternalID: %v", id)
+			continue
+		}
+
+		err := l.processor.applyOrderStatus(invoice, status, l.mapStatus)
+		if err != nil {
+			log.Printf("[LuckyPay] не удалось обработать статус счета InvoiceID: %v, error: %v", invoice.ID, err)
+			continue
+		}
+		delete(byExternalID, id)
+	}
+}
+
+// narrowSyncWindow оставляет keep/of окна [from, to]. Фильтр updated_to точен до секунды,
+// более узкое окно не сузить
+func narrowSyncWindow(from, to time.Time, keep, of int) (time.Time, error) {
+	narrowed := from.Add(to.Sub(from) / time.Duration(of) * time.Duration(keep))
+	if narrowed.Sub(from) < time.Second {
+		return to, fmt.Errorf("[LuckyPay] больше %d страниц за секунду с %s, отметка синхронизации не сдвинута", keep, from.Format(time.RFC3339))
+	}
+	return narrowed, nil
+}
 
-	req, err := http.NewRequest("GET", l.config.Endpoint+"/api/v/1/order", bytes.NewBuffer(reqBody))
+// fetchOrders запрашивает страницу завершённых заявок, обновлённых в окне [from, to].
+// Возвращает заявки и число страниц, если LuckyPay его сообщил
+func (l *LuckyPayExchanger) fetchOrders(page int, from, to time.Time) ([]interface{}, int, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("size", strconv.Itoa(luckyPayPageSize))
+	query.Set("order_side", "Buy")
+	query.Set("order_status", "Completed,CanceledByTimeout,CanceledByService")
+	query.Set("updated_from", from.Format(time.RFC3339))
+	query.Set("updated_to", to.Format(time.RFC3339))
+
+	req, err := http.NewRequest("GET", l.config.Endpoint+"/api/v1/order/?"+query.Encode(), nil)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("X-API-Key", l.config.APIKey)
 
 	client := &http.Client{Timeout: 8 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return errors.New("[LuckyPay] сервер вернул ошибку: " + string(body))
+		return nil, 0, fmt.Errorf("[LuckyPay] %w", &StatusError{Code: resp.StatusCode, Body: string(body)})
 	}
 
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
-		return err
+		return nil, 0, err
 	}
 
 	success, ok := result["success"].(bool)
 	if !ok || !success {
 		msg, _ := result["message"].(string)
-		return fmt.Errorf("[LuckyPay] сервер вернул ошибку: %v", msg)
+		return nil, 0, fmt.Errorf("[LuckyPay] сервер вернул ошибку: %v", msg)
 	}
 
 	orders, ok := result["orders"].(map[string]interface{})
 	if !ok {
-		return errors.New("[LuckyPay] не удалось получить 'orders'")
+		return nil, 0, errors.New("[LuckyPay] не удалось получить 'orders'")
 	}
 
-	ordersItems, ok := orders["items"].([]interface{})
+	items, ok := orders["items"].([]interface{})
 	if !ok {
-		return errors.New("[LuckyPay] не удалось получить 'items'")
+		return nil, 0, errors.New("[LuckyPay] не удалось получить 'items'")
 	}
-	processor := NewProcessor()
 
-	for _, orderItem := range ordersItems {
-		orderItemData, ok := orderItem.(map[string]interface{})
-		if !ok {
-			log.Println("[LuckyPay] не удалось получить информацию о orderItemData")
-		}
+	pages, _ := orders["pages"].(float64)
+	return items, int(pages), nil
+}
 
-		id, ok := orderItemData["id"].(string)
-		if !ok {
-			log.Println("[LuckyPay]Не удалось получить 'id'")
-		}
+// mapStatus переводит статус заявки LuckyPay в статус счета
+func (l *LuckyPayExchanger) mapStatus(orderStatus string) (models.InvoiceStatus, error) {
+	switch orderStatus {
+	case "Completed":
+		return models.StatusPaid, nil
+	case "CanceledByTimeout":
+		return models.StatusCancelTime, nil
+	case "CanceledByService":
+		return models.StatusCancelOperator, nil
+	default:
+		return "", errors.New("Не получилось обработать статус")
+	}
+}
 
-		status, ok := orderItemData["status"].(string)
-		if !ok {
-			log.Println("[LuckyPay] не удалось получить 'status'")
-		}
+// FindOrder ищет незавершённую заявку по client_order_id = ID счета
+func (l *LuckyPayExchanger) FindOrder(invoiceID uint64) (models.DetailsRequisites, bool, error) {
+	query := url.Values{}
+	query.Set("client_order_id", fmt.Sprintf("%d", invoiceID))
+	query.Set("page", "1")
+	query.Set("size", "1")
 
-		invoice, err := processor.MysqlLogger.GetInvoiceByExternalIDAndServiceID(id, serviceID)
-		if err != nil || invoice == nil {
-			log.Println("[LuckyPay] не удалось получить счет по ExternalID: %v", id)
-			continue
-		}
+	req, err := http.NewRequest("GET", l.config.Endpoint+"/api/v1/order/?"+query.Encode(), nil)
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-Key", l.config.APIKey)
 
-		err = l.processStatusInvoice(processor, *invoice, status)
-		if err != nil {
-			log.Println("[LuckyPay] не удалось обработать статус счета InvoiceID: %v", invoice.ID)
-			continue
-		}
+	client := &http.Client{Timeout: 8 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
 	}
 
-	return nil
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return models.DetailsRequisites{}, false, fmt.Errorf("[LuckyPay] %w", &StatusError{Code: resp.StatusCode, Body: string(body)})
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+
+	orders, _ := result["orders"].(map[string]interface{})
+	items, _ := orders["items"].([]interface{})
+	if len(items) == 0 {
+		return models.DetailsRequisites{}, false, nil
+	}
+
+	order, ok := items[0].(map[string]interface{})
+	if !ok {
+		return models.DetailsRequisites{}, false, errors.New("[LuckyPay] 'items[0]' не является объектом")
+	}
+
+	// Завершённая заявка не годится для повторной выдачи
+	status, _ := order["status"].(string)
+	if mapped, err := l.mapStatus(status); err == nil && mapped != models.StatusSearch {
+		return models.DetailsRequisites{}, false, nil
+	}
+
+	details, err := l.ReturnFormattedDetails(order)
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+	return details, true, nil
 }
 
-func (l *LuckyPayExchanger) processStatusInvoice(processor *Processor, invoice models.InvoiceCheckLite, orderStatus string) error {
-	switch orderStatus {
-	case "Completed":
-		err := processor.MysqlLogger.UpdateInvoiceStatus(invoice, "paid")
-		if err != nil {
-			return err
-		}
-	case "CanceledByTimeout":
-		err := processor.MysqlLogger.UpdateInvoiceStatus(invoice, "cancel_time")
-		if err != nil {
-			return err
-		}
-	case "CanceledByService":
-		err := processor.MysqlLogger.UpdateInvoiceStatus(invoice, "cancel_operator")
-		if err != nil {
-			return err
-		}
-	default:
-		return errors.New("Не получилось обработать статус")
+// CancelOrder отменяет заявку LuckyPay
+func (l *LuckyPayExchanger) CancelOrder(invoiceID uint64, externalID string) error {
+	urlApi := l.config.Endpoint + "/api/v1/order/" + url.PathEscape(externalID) + "/cancel"
+
+	req, err := http.NewRequest("POST", urlApi, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-Key", l.config.APIKey)
+
+	client := &http.Client{Timeout: 8 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return fmt.Errorf("[LuckyPay] %w", &StatusError{Code: resp.StatusCode, Body: string(body)})
+	}
+
+	l.processor.logApiRequest(l.config, urlApi, resp.StatusCode, string(body), "", invoiceID)
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	if success, _ := result["success"].(bool); !success {
+		msg, _ := result["message"].(string)
+		return fmt.Errorf("[LuckyPay] заявка не отменена: %v", msg)
 	}
 	return nil
 }
@@ -188,10 +402,10 @@ func (l *LuckyPayExchanger) GetRequisites(task models.InvoiceTask, ex models.Exc
 		}
 
 		if resp.StatusCode != 200 && resp.StatusCode != 201 {
-			return nil, body, errors.New("сервер вернул ошибку")
+			return nil, body, &StatusError{Code: resp.StatusCode}
 		}
 
-		l.processor.ClickLogger.ApiRequests(urlApi, resp.StatusCode, string(body), string(reqBody), task.Invoice.ID, ex.ID)
+		l.processor.logApiRequest(ex, urlApi, resp.StatusCode, string(body), string(reqBody), task.Invoice.ID)
 
 		return resp, body, nil
 	}
@@ -203,7 +417,7 @@ func (l *LuckyPayExchanger) GetRequisites(task models.InvoiceTask, ex models.Exc
 		bodyMap["payment_method_id"] = "2ec6dbd6-49a5-45d0-bd6d-b0134ee4639a"
 		_, body, err = tryRequest()
 		if err != nil {
-			return models.DetailsRequisites{}, fmt.Errorf("оба метода оплаты не сработали: %v (%s)", err, string(body))
+			return models.DetailsRequisites{}, fmt.Errorf("оба метода оплаты не сработали: %w (%s)", err, string(body))
 		}
 	}
 
@@ -241,8 +455,15 @@ func (l *LuckyPayExchanger) ReturnFormattedDetails(data map[string]interface{})
 		return models.DetailsRequisites{}, errors.New("'amount_payable' не число")
 	}
 
-	externalMethodName := data["method_name"].(string)
-	externalHolderName := data["holder_name"].(string)
+	externalMethodName, ok := data["method_name"].(string)
+	if !ok {
+		return models.DetailsRequisites{}, errors.New("'method_name' is empty")
+	}
+
+	externalHolderName, ok := data["holder_name"].(string)
+	if !ok {
+		return models.DetailsRequisites{}, errors.New("'holder_name' is empty")
+	}
 
 	important := make(map[string]interface{})
 	data["important"] = important
@@ -0,0 +1,62 @@
+package exchanger
+
+import (
+	"payment-service-go/memory"
+	"payment-service-go/models"
+	"payment-service-go/sandbox"
+	"testing"
+	"time"
+)
+
+func TestLuckyPayExpireUnsynced(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		expired  time.Duration // сколько назад истёк счет
+		want     models.InvoiceStatus
+		canceled bool // заявка отменена у обменника
+	}{
+		{name: "политика error", policy: UncheckedError, expired: 2 * time.Hour, want: models.StatusError},
+		{name: "политика cancel", policy: UncheckedCancel, expired: 2 * time.Hour, want: models.StatusCancelTime, canceled: true},
+		{name: "политика keep", policy: UncheckedKeep, expired: 2 * time.Hour, want: models.StatusPending},
+		{name: "UNCHECKED_GRACE не прошёл", policy: UncheckedError, expired: 10 * time.Minute, want: models.StatusPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := sandbox.NewTestServer(sandbox.Config{Credentials: map[string]sandbox.Credentials{
+				sandbox.LuckyPay: {APIKey: "luckypay-key"},
+			}})
+			defer ts.Close()
+
+			t.Setenv("UNCHECKED_POLICY", tt.policy)
+			t.Setenv("UNCHECKED_GRACE", "30m")
+			p, store, audit := newTestProcessor(t)
+			ex := ts.Exchanger(3, sandbox.LuckyPay)
+			store.AddExchanger(testServiceID, ex)
+			now := time.Now().UTC()
+			store.AddInvoice(memory.Invoice{
+				ID:          10,
+				ServiceID:   testServiceID,
+				Status:      models.StatusPending,
+				ExchangerID: ex.ID,
+				ExternalID:  "lp-order-1",
+				ExpiryAt:    now.Add(-tt.expired).Format("2006-01-02 15:04:05"),
+			})
+			// Заявка не вернулась ни в одном окне, отметка уже за сроком счета
+			store.SaveSyncMark(testServiceID, ex.ID, now.Add(-time.Minute))
+
+			if err := NewLuckyPayExchanger(ex, p).CheckInvoices(nil, testServiceID); err != nil {
+				t.Fatalf("CheckInvoices: %v", err)
+			}
+
+			invoice, _ := store.Invoice(10)
+			if invoice.Status != tt.want {
+				t.Errorf("статус счета = %q, want %q", invoice.Status, tt.want)
+			}
+			if canceled := len(audit.Records().OrderCancels) > 0; canceled != tt.canceled {
+				t.Errorf("заявка отменена = %v, want %v", canceled, tt.canceled)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package exchanger
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"payment-service-go/metrics"
+	"payment-service-go/models"
+	"time"
+)
+
+// observeRequisites учитывает вызов GetRequisites в метриках обменника
+func observeRequisites(ex models.Exchanger, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+		metrics.RequisitesErrors.WithLabelValues(ex.Name, requisitesErrorCause(err)).Inc()
+	}
+	metrics.RequisitesDuration.WithLabelValues(ex.Name, result).Observe(time.Since(start).Seconds())
+}
+
+// requisitesErrorCause - причина ошибки для метки cause: timeout, network, http_4xx, http_5xx,
+// decode - ответ не JSON, rejected - обменник ответил, но реквизиты не выдал
+func requisitesErrorCause(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.Code >= 500 {
+			return "http_5xx"
+		}
+		return "http_4xx"
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return "decode"
+	}
+	return "rejected"
+}
+
+// countChecked учитывает счета, отправленные на проверку статуса, source - process_invoices или poller
+func countChecked(exchangerName, source string, n int, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	metrics.InvoicesChecked.WithLabelValues(exchangerName, source, result).Add(float64(n))
+}
+
+// recordTransition учитывает переход статуса счета, source - кто меняет статус (updated_by)
+func recordTransition(status models.InvoiceStatus, source, result string) {
+	metrics.StatusTransitions.WithLabelValues(string(status), source, result).Inc()
+}
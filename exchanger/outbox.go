@@ -0,0 +1,101 @@
+package exchanger
+
+import (
+	"log"
+	"payment-service-go/models"
+	"time"
+)
+
+const (
+	outboxInterval  = 2 * time.Second
+	outboxBatch     = 100
+	outboxRetention = 7 * 24 * time.Hour
+	outboxMaxDelay  = 5 * time.Minute
+)
+
+// runOutboxRelay доставляет события из invoice_outbox в invoice_history и RabbitMQ.
+// Доставка "хотя бы один раз": после сбоя событие может прийти повторно, подписчики дедуплицируют по ID
+func (p *Processor) runOutboxRelay() {
+	defer p.background.Done()
+
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+	lastPurge := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Полный пакет без сбоев - скорее всего есть ещё. Пакет с ошибками ждёт следующего тика,
+			// иначе недоставляемые события крутили бы цикл вхолостую
+			for {
+				fetched, delivered := p.relayOutbox()
+				if fetched < outboxBatch || delivered < fetched {
+					break
+				}
+			}
+			if time.Since(lastPurge) > time.Hour {
+				if err := p.MysqlLogger.PurgeOutbox(time.Now().Add(-outboxRetention)); err != nil {
+					log.Printf("Outbox: не удалось удалить доставленные события: %v", err)
+				}
+				lastPurge = time.Now()
+			}
+		case <-p.stop:
+			// Последний проход, чтобы не оставлять события до следующего запуска
+			p.relayOutbox()
+			return
+		}
+	}
+}
+
+// relayOutbox обрабатывает один пакет и возвращает его размер и число полностью доставленных событий
+func (p *Processor) relayOutbox() (fetched, delivered int) {
+	entries, err := p.MysqlLogger.FetchOutbox(outboxBatch)
+	if err != nil {
+		log.Printf("Outbox: не удалось прочитать события: %v", err)
+		return 0, 0
+	}
+
+	for _, entry := range entries {
+		event := entry.Event
+		var failure error
+
+		if !entry.ClickHouseDone {
+			var details *string
+			if d, ok := event.Data["details"].(string); ok {
+				details = &d
+			}
+			err := p.ClickLogger.InvoiceHistoryInsert(event.InvoiceID, event.UpdatedBy, event.Status, nil, details, event.OccurredAt)
+			if err == nil {
+				err = p.MysqlLogger.MarkOutboxDelivered(entry.ID, models.OutboxClickHouse)
+			}
+			if err != nil {
+				failure = err
+			}
+		}
+
+		if !entry.RabbitDone {
+			err := p.Events.Publish(event)
+			if err == nil {
+				err = p.MysqlLogger.MarkOutboxDelivered(entry.ID, models.OutboxRabbit)
+			}
+			if err != nil {
+				failure = err
+			}
+		}
+
+		if failure != nil {
+			delay := time.Duration(1<<uint(min(entry.Attempts, 8))) * time.Second
+			if delay > outboxMaxDelay {
+				delay = outboxMaxDelay
+			}
+			log.Printf("Outbox: событие %s счета %d не доставлено, повтор через %s: %v", event.Type, event.InvoiceID, delay, failure)
+			if err := p.MysqlLogger.MarkOutboxFailed(entry.ID, time.Now().Add(delay), failure.Error()); err != nil {
+				log.Printf("Outbox: не удалось отложить событие %d: %v", entry.ID, err)
+			}
+			continue
+		}
+		delivered++
+	}
+
+	return len(entries), delivered
+}
@@ -0,0 +1,181 @@
+package exchanger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"payment-service-go/models"
+	"time"
+)
+
+// Poller проверяет статусы счетов, пока реквизиты действуют.
+// Чаще всего - сразу после выдачи реквизитов и перед истечением, когда оплата наиболее вероятна
+type Poller struct {
+	config    PollConfig
+	processor *Processor
+
+	next map[uint64]time.Time // время следующей проверки счета
+}
+
+func NewPoller(config PollConfig, processor *Processor) *Poller {
+	return &Poller{
+		config:    config,
+		processor: processor,
+		next:      make(map[uint64]time.Time),
+	}
+}
+
+// Run опрашивает статусы, пока не закрыт stop
+func (p *Poller) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.config.Tick)
+	defer ticker.Stop()
+
+	log.Println("Запуск опроса статусов активных счетов…")
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.poll(time.Now()); err != nil {
+				log.Printf("Ошибка опроса статусов: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (p *Poller) poll(now time.Time) error {
+	invoices, err := p.processor.MysqlLogger.GetActiveInvoices(now.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return err
+	}
+
+	active := make(map[uint64]bool, len(invoices))
+	grouped := make(map[string]*models.ExchangerWithInvoices)
+	var order []string
+
+	for _, inv := range invoices {
+		active[inv.ID] = true
+		next, scheduled := p.next[inv.ID]
+		if !scheduled {
+			// Первая проверка - через интервал после создания, а не сразу
+			next = inv.CreatedAt.Add(p.interval(inv, inv.CreatedAt))
+			p.next[inv.ID] = next
+		}
+		if next.After(now) {
+			continue
+		}
+
+		key := fmt.Sprintf("%d:%d", inv.ServiceID, inv.Exchanger.ID)
+		if _, exists := grouped[key]; !exists {
+			grouped[key] = &models.ExchangerWithInvoices{ServiceID: inv.ServiceID, Exchanger: inv.Exchanger}
+			order = append(order, key)
+		}
+		grouped[key].Invoices = append(grouped[key].Invoices, models.InvoiceCheckLite{
+			ID:         inv.ID,
+			ExternalID: inv.ExternalID,
+			ExpiryAt:   inv.ExpiryAt,
+		})
+	}
+
+	// Истёкшие и закрытые счета больше не опрашиваются, их забирает ProcessInvoices
+	for id := range p.next {
+		if !active[id] {
+			delete(p.next, id)
+		}
+	}
+
+	byID := make(map[uint64]models.InvoiceCheck, len(invoices))
+	for _, inv := range invoices {
+		byID[inv.ID] = inv
+	}
+
+	for _, key := range order {
+		group := grouped[key]
+		checked := p.check(group, now)
+		for _, inv := range checked {
+			p.next[inv.ID] = now.Add(p.interval(byID[inv.ID], now))
+		}
+	}
+	return nil
+}
+
+// check проверяет счета группы в пределах лимита обменника и возвращает проверенные.
+// Непроверенные остаются к проверке на следующем такте
+func (p *Poller) check(group *models.ExchangerWithInvoices, now time.Time) []models.InvoiceCheckLite {
+	exchanger, err := p.processor.newExchanger(group.Exchanger, CapCheckStatus)
+	if err != nil {
+		// Статус придёт через callback или обработается политикой UNCHECKED_POLICY после истечения
+		return group.Invoices
+	}
+	reg, _ := Lookup(group.Exchanger.Name)
+	breakers := p.processor.breakers
+	limits := p.processor.limits
+
+	if reg.Can(CapSyncCheck) {
+		if !breakers.Allow(group.Exchanger) {
+			return nil
+		}
+		// Синхронизация за один вызов проходит все счета сервиса и сама расходует лимит на каждую страницу
+		err := exchanger.CheckInvoices(group.Invoices, group.ServiceID)
+		if errors.Is(err, errRateLimited) {
+			breakers.Release(group.Exchanger)
+			log.Printf("Опрос статусов %s: лимит запросов исчерпан, отложено %d счетов", group.Exchanger.Name, len(group.Invoices))
+			return nil
+		}
+		breakers.Record(group.Exchanger, err)
+		countChecked(group.Exchanger.Name, "poller", len(group.Invoices), err)
+		if err != nil {
+			log.Printf("Опрос статусов %s: %v", group.Exchanger.Name, err)
+		}
+		return group.Invoices
+	}
+
+	var checked []models.InvoiceCheckLite
+	for len(group.Invoices) > 0 {
+		// Каждый вызов - отдельный запрос к автомату: в half_open следующая пачка уходит,
+		// только если пробный вызов замкнул автомат
+		if !breakers.Allow(group.Exchanger) {
+			return checked
+		}
+
+		var chunk []models.InvoiceCheckLite
+		if reg.Can(CapBatchCheck) {
+			if limits.take(group.Exchanger.Name, 1, now) == 1 {
+				size := p.config.BatchSize
+				if size > len(group.Invoices) {
+					size = len(group.Invoices)
+				}
+				chunk = group.Invoices[:size]
+			}
+		} else {
+			// Без пакетной проверки каждый счет - отдельный запрос
+			chunk = group.Invoices[:limits.take(group.Exchanger.Name, len(group.Invoices), now)]
+		}
+		if len(chunk) == 0 {
+			breakers.Release(group.Exchanger)
+			break
+		}
+		group.Invoices = group.Invoices[len(chunk):]
+
+		err := exchanger.CheckInvoices(chunk, group.ServiceID)
+		breakers.Record(group.Exchanger, err)
+		countChecked(group.Exchanger.Name, "poller", len(chunk), err)
+		if err != nil {
+			log.Printf("Опрос статусов %s: %v", group.Exchanger.Name, err)
+		}
+		checked = append(checked, chunk...)
+	}
+
+	if len(group.Invoices) > 0 {
+		log.Printf("Опрос статусов %s: лимит запросов исчерпан, отложено %d счетов", group.Exchanger.Name, len(group.Invoices))
+	}
+	return checked
+}
+
+// interval - пауза до следующей проверки счета
+func (p *Poller) interval(inv models.InvoiceCheck, now time.Time) time.Duration {
+	if now.Sub(inv.CreatedAt) < p.config.FastWindow || inv.ExpiryAt.Sub(now) < p.config.FastWindow {
+		return p.config.Fast
+	}
+	return p.config.Slow
+}
@@ -0,0 +1,156 @@
+package exchanger
+
+import (
+	"errors"
+	"log"
+	"payment-service-go/models"
+	"payment-service-go/redact"
+	"time"
+)
+
+var errBreakerOpen = errors.New("автомат обменника разомкнут")
+
+// Результаты отмены заявки у обменника
+const (
+	cancelDone        = "cancelled"
+	cancelFailed      = "failed"
+	cancelUnsupported = "unsupported" // обменник не умеет отменять заявки
+)
+
+const (
+	attemptSuccess  = "success"
+	attemptError    = "error"
+	attemptReleased = "released" // реквизиты получены, но не понадобились
+)
+
+type raceCandidate struct {
+	config    models.Exchanger
+	exchanger Exchanger
+}
+
+type attemptResult struct {
+	config     models.Exchanger
+	requisites models.DetailsRequisites
+	err        error
+	duration   time.Duration
+}
+
+// processRace опрашивает обменники параллельно и берёт первые валидные реквизиты
+func (p *Processor) processRace(task models.InvoiceTask) (string, error) {
+	var candidates []raceCandidate
+	for _, ex := range task.Exchangers {
+		exchanger, err := p.newExchanger(ex, CapCreateOrder)
+		if err != nil {
+			log.Printf("Пропуск обменника: %v", err)
+			continue
+		}
+		if p.breakers.IsOpen(ex) {
+			log.Printf("Пропуск обменника %s: автомат разомкнут", ex.Name)
+			continue
+		}
+		candidates = append(candidates, raceCandidate{config: ex, exchanger: exchanger})
+	}
+	if len(candidates) == 0 {
+		return "", errors.New("реквизиты не найдены ни одним обменником")
+	}
+
+	width := p.config.RaceWidth
+	if width <= 0 || width > len(candidates) {
+		width = len(candidates)
+	}
+
+	// Буфер на все попытки, чтобы опоздавшие горутины не блокировались
+	results := make(chan attemptResult, len(candidates))
+	next, inFlight := 0, 0
+	launch := func() {
+		candidate := candidates[next]
+		next++
+		inFlight++
+		go func() {
+			if !p.breakers.Allow(candidate.config) {
+				results <- attemptResult{config: candidate.config, err: errBreakerOpen}
+				return
+			}
+			start := time.Now()
+			requisites, err := p.requestRequisites(task, candidate.config, candidate.exchanger)
+			p.breakers.Record(candidate.config, err)
+			results <- attemptResult{config: candidate.config, requisites: requisites, err: err, duration: time.Since(start)}
+		}()
+	}
+	for inFlight < width {
+		launch()
+	}
+
+	budget := time.NewTimer(p.config.LatencyBudget)
+	defer budget.Stop()
+
+	for inFlight > 0 {
+		select {
+		case res := <-results:
+			inFlight--
+			if errors.Is(res.err, errBreakerOpen) {
+				log.Printf("Пропуск обменника %s: автомат разомкнут", res.config.Name)
+				if next < len(candidates) {
+					launch()
+				}
+				continue
+			}
+			if res.err != nil {
+				p.recordAttempt(task, res.config, attemptError, res.duration)
+				p.ClickLogger.LogErrorApiRequests(task.Invoice.ID, res.config.ID, "Не удалось получить реквизиты: "+res.err.Error())
+				log.Printf("Ошибка в %s: %v", res.config.Name, res.err)
+				if next < len(candidates) {
+					launch()
+				}
+				continue
+			}
+
+			p.recordAttempt(task, res.config, attemptSuccess, res.duration)
+			log.Printf("Реквизиты найдены через %s: %s", res.config.Name, redact.Default().Mask(res.requisites.Requisites))
+			err := p.saveRequisites(task, res.config, res.requisites)
+			p.background.Add(1)
+			go p.drainRace(task, results, inFlight)
+			if err != nil {
+				return "", err
+			}
+			return res.requisites.Requisites, nil
+
+		case <-budget.C:
+			p.background.Add(1)
+			go p.drainRace(task, results, inFlight)
+			return "", errors.New("превышен лимит времени на поиск реквизитов")
+		}
+	}
+
+	return "", errors.New("реквизиты не найдены ни одним обменником")
+}
+
+// drainRace дожидается оставшихся попыток и освобождает лишние заявки
+func (p *Processor) drainRace(task models.InvoiceTask, results <-chan attemptResult, pending int) {
+	defer p.background.Done()
+
+	for i := 0; i < pending; i++ {
+		res := <-results
+		if errors.Is(res.err, errBreakerOpen) {
+			continue
+		}
+		if res.err != nil {
+			p.recordAttempt(task, res.config, attemptError, res.duration)
+			p.ClickLogger.LogErrorApiRequests(task.Invoice.ID, res.config.ID, "Не удалось получить реквизиты: "+res.err.Error())
+			continue
+		}
+		p.releaseOrder(task, res.config, res.requisites, res.duration)
+	}
+}
+
+// releaseOrder отменяет заявку у обменника, реквизиты которой не были выданы клиенту
+func (p *Processor) releaseOrder(task models.InvoiceTask, ex models.Exchanger, details models.DetailsRequisites, duration time.Duration) {
+	p.recordAttempt(task, ex, attemptReleased, duration)
+	log.Printf("Заявка %s в %s не использована для счета %d", details.ID, ex.Name, task.Invoice.ID)
+	p.cancelOrder(ex, task.Invoice.ID, details.ID, attemptReleased)
+}
+
+// recordAttempt пишет попытку получения реквизитов в exchangers_analytics
+func (p *Processor) recordAttempt(task models.InvoiceTask, ex models.Exchanger, status string, duration time.Duration) {
+	p.ClickLogger.LogAnalytics(task.Invoice.ID, status, ex.Name, float64(duration.Milliseconds()), task.Invoice.CreatedAt)
+}
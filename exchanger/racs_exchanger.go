@@ -1,6 +1,8 @@
 package exchanger
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,16 +12,23 @@ import (
 	"net/url"
 	"payment-service-go/models"
 	"strconv"
+	"strings"
 	"time"
 )
 
+func init() {
+	Register("Racks", CapCreateOrder|CapCheckStatus|CapCallback, func(config models.Exchanger, processor *Processor) Exchanger {
+		return NewRacksExchanger(config, processor)
+	})
+}
+
 type RacksExchanger struct {
 	config    models.Exchanger
-	processor Processor
+	processor *Processor
 }
 
 func NewRacksExchanger(config models.Exchanger, processor *Processor) *RacksExchanger {
-	return &RacksExchanger{config: config, processor: *processor}
+	return &RacksExchanger{config: config, processor: processor}
 }
 
 func (r *RacksExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, serviceID uint64) error {
@@ -51,64 +60,98 @@ func (r *RacksExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, servi
 		}
 
 		if resp.StatusCode != 200 && resp.StatusCode != 201 {
-			return nil, body, errors.New("сервер вернул ошибку")
+			return nil, body, &StatusError{Code: resp.StatusCode, Body: string(body)}
 		}
 
 		return resp, body, nil
 	}
 
+	// Ошибки по счетам копятся, чтобы автомат и метрики видели недоступность обменника
+	var errs []error
 	for _, invoice := range invoices {
 		_, body, err := tryRequest(invoice.ExternalID)
 		if err != nil {
 			r.processor.ClickLogger.LogErrorApiRequests(invoice.ID, r.config.ID, string(body))
-			fmt.Errorf("[Racks] не удалось проверить счет InvoiceID: %v, error: %v", invoice.ID, err)
+			log.Printf("[Racks] не удалось проверить счет InvoiceID: %v, error: %v", invoice.ID, err)
+			errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
 			continue
 		}
 		var result map[string]interface{}
 		if err := json.Unmarshal(body, &result); err != nil {
 			r.processor.ClickLogger.LogErrorApiRequests(invoice.ID, r.config.ID, string(body))
-			fmt.Errorf("[Racks] не удалось проверить счет InvoiceID: %v, error: %v", invoice.ID, err)
+			log.Printf("[Racks] не удалось проверить счет InvoiceID: %v, error: %v", invoice.ID, err)
+			errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
 			continue
 		}
 
 		status, ok := result["status"].(string)
 		if !ok {
 			r.processor.ClickLogger.LogErrorApiRequests(invoice.ID, r.config.ID, string(body))
-			fmt.Errorf("[Racks] не удалось получить статус у InvoiceID: %v, error: %v", invoice.ID, err)
+			log.Printf("[Racks] не удалось получить статус у InvoiceID: %v", invoice.ID)
+			errs = append(errs, fmt.Errorf("счет %d: не удалось получить 'status'", invoice.ID))
 			continue
 		}
 
-		err = r.processStatusInvoice(&r.processor, invoice, status)
+		err = r.processor.applyOrderStatus(invoice, status, r.mapStatus)
 
 		if err != nil {
-			fmt.Errorf("[Racks] не удалось обрабатотать статус у InvoiceID: %v, error: %v", invoice.ID, err)
+			log.Printf("[Racks] не удалось обрабатотать статус у InvoiceID: %v, error: %v", invoice.ID, err)
+			// Отклонённый переход уже записан и не говорит о сбое обменника
+			var transitionErr *models.TransitionError
+			if !errors.As(err, &transitionErr) {
+				errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
+			}
 			continue
 		}
 	}
 
+	if len(errs) > 0 {
+		return fmt.Errorf("[Racks] %w", errors.Join(errs...))
+	}
 	return nil
 }
 
-func (r *RacksExchanger) processStatusInvoice(processor *Processor, invoice models.InvoiceCheckLite, orderStatus string) error {
-	var status string
+// mapStatus переводит статус заявки Racks в статус счета, пустая строка - статус не меняется
+func (r *RacksExchanger) mapStatus(orderStatus string) (models.InvoiceStatus, error) {
 	switch orderStatus {
 	case "Done":
-		status = "paid"
+		return models.StatusPaid, nil
 	case "Pending":
-		return nil
+		return "", nil
 	case "Cancel":
-		status = "cancel_time"
+		return models.StatusCancelTime, nil
 	default:
-		return errors.New("Не получилось обработать статус")
+		return "", errors.New("Не получилось обработать статус")
 	}
+}
 
-	err := processor.MysqlLogger.UpdateInvoiceStatus(invoice, status)
-	details := "OrderStatus: " + orderStatus
-	r.processor.ClickLogger.InvoiceHistoryInsert(invoice.ID, "golang_process_status", status, nil, &details)
-	if err != nil {
-		return err
+// ParseCallback разбирает уведомление Racks: id - ID заявки, status - статус
+func (r *RacksExchanger) ParseCallback(body []byte) (string, string, error) {
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", "", err
 	}
 
+	externalID, ok := result["id"].(string)
+	if !ok || externalID == "" {
+		return "", "", errors.New("не удалось получить 'id'")
+	}
+	status, ok := result["status"].(string)
+	if !ok {
+		return "", "", errors.New("не удалось получить 'status'")
+	}
+	return externalID, status, nil
+}
+
+// VerifyCallback сверяет X-Signature с HMAC-SHA256 тела на private_key, который передаётся при создании заявки
+func (r *RacksExchanger) VerifyCallback(header http.Header, body []byte) error {
+	h := hmac.New(sha256.New, []byte(r.config.SecretKey))
+	h.Write(body)
+	expected := fmt.Sprintf("%x", h.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(header.Get("X-Signature")))) {
+		return errors.New("X-Signature не совпадает")
+	}
 	return nil
 }
 
@@ -145,16 +188,15 @@ func (r *RacksExchanger) GetRequisites(task models.InvoiceTask, ex models.Exchan
 	}
 
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return models.DetailsRequisites{}, errors.New("сервер вернул ошибку: " + string(body))
+		return models.DetailsRequisites{}, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
-	r.processor.ClickLogger.ApiRequests(urlApi, resp.StatusCode, string(body), encoded, task.Invoice.ID, ex.ID)
+	r.processor.logApiRequest(ex, urlApi, resp.StatusCode, string(body), encoded, task.Invoice.ID)
 
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
 		return models.DetailsRequisites{}, err
 	}
-	log.Printf("result: %v", result)
 
 	return r.ReturnFormattedDetails(result)
 }
@@ -194,7 +236,8 @@ func (r *RacksExchanger) ReturnFormattedDetails(data map[string]interface{}) (mo
 		return models.DetailsRequisites{}, errors.New("не удалось получить 'amount'")
 	}
 
-	untilAtRaw, ok := order["time_unix"].(int64)
+	// Числа из JSON приходят как float64
+	untilAtRaw, ok := order["time_unix"].(float64)
 	if !ok {
 		return models.DetailsRequisites{}, errors.New("не удалось получить 'time_unix'")
 	}
@@ -204,7 +247,8 @@ func (r *RacksExchanger) ReturnFormattedDetails(data map[string]interface{}) (mo
 		return models.DetailsRequisites{}, errors.New("не удалось конвертировать 'amount'")
 	}
 
-	untilAt := time.Unix(untilAtRaw, 0).Format("2006-01-02 15:04:05")
+	// Сроки заявок хранятся в UTC, как у остальных обменников
+	untilAt := time.Unix(int64(untilAtRaw), 0).UTC().Format("2006-01-02 15:04:05")
 
 	return models.DetailsRequisites{
 		ID:         externalOrderID,
@@ -0,0 +1,77 @@
+package exchanger
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// errRateLimited - лимит запросов к обменнику исчерпан, проверка отложена до следующего прохода
+var errRateLimited = errors.New("лимит запросов к обменнику исчерпан")
+
+// rateLimits - лимиты запросов по имени обменника из POLL_RATE_LIMITS. Общие для Poller, ProcessInvoices
+// и постраничной синхронизации, чтобы вместе они не превышали лимит обменника
+type rateLimits struct {
+	config PollConfig
+
+	mu       sync.Mutex
+	limiters map[string]*rateLimiter
+}
+
+func newRateLimits(config PollConfig) *rateLimits {
+	return &rateLimits{config: config, limiters: make(map[string]*rateLimiter)}
+}
+
+// take забирает до n токенов обменника и возвращает, сколько удалось взять
+func (r *rateLimits) take(name string, n int, now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limiter(name).take(n, now)
+}
+
+// available возвращает, сколько запросов к обменнику можно сделать сейчас, не расходуя токены
+func (r *rateLimits) available(name string, now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limiter := r.limiter(name)
+	limiter.refill(now)
+	return int(math.Floor(limiter.tokens))
+}
+
+func (r *rateLimits) limiter(name string) *rateLimiter {
+	limiter, ok := r.limiters[name]
+	if !ok {
+		limiter = newRateLimiter(r.config.rateLimit(name))
+		r.limiters[name] = limiter
+	}
+	return limiter
+}
+
+// rateLimiter - корзина токенов: perMinute запросов в минуту, запас - на четверть минуты
+type rateLimiter struct {
+	rate   float64 // токенов в секунду
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	burst := math.Max(1, float64(perMinute)/4)
+	return &rateLimiter{rate: float64(perMinute) / 60, burst: burst, tokens: burst}
+}
+
+// take забирает до n токенов и возвращает, сколько удалось взять
+func (l *rateLimiter) take(n int, now time.Time) int {
+	l.refill(now)
+	granted := int(math.Min(float64(n), math.Floor(l.tokens)))
+	l.tokens -= float64(granted)
+	return granted
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
@@ -0,0 +1,172 @@
+package exchanger
+
+import (
+	"errors"
+	"log"
+	"payment-service-go/models"
+	"time"
+)
+
+const recoveryBatch = 500
+
+// Действия восстановления, пишутся в exchangers_orphan_orders
+const (
+	recoveryAttached  = "attached"  // заявка привязана к счету
+	recoveryCancelled = "cancelled" // заявка отменена у обменника
+	recoveryNotFound  = "not_found" // заявка у обменника не создалась
+	recoveryFlagged   = "flagged"   // нужен оператор
+)
+
+// Источники потерянных заявок
+const (
+	sourceJournal     = "journal"
+	sourceApiRequests = "api_requests"
+)
+
+// runRecovery разбирает заявки, потерянные при сбое: при старте и затем по расписанию
+func (p *Processor) runRecovery(config RecoveryConfig) {
+	defer p.background.Done()
+
+	p.recover(config)
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.recover(config)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *Processor) recover(config RecoveryConfig) {
+	now := time.Now()
+	since, before := now.Add(-config.Lookback), now.Add(-config.Grace)
+
+	orphans, err := p.MysqlLogger.GetOrphanAttempts(since, before, recoveryBatch)
+	if err != nil {
+		log.Printf("Восстановление: не удалось прочитать журнал заявок: %v", err)
+	}
+	for _, orphan := range orphans {
+		p.recoverOrphan(orphan, now)
+	}
+
+	pairs, err := p.ClickLogger.SuccessfulApiRequests(since, before)
+	if err != nil {
+		log.Printf("Восстановление: не удалось прочитать api_requests: %v", err)
+		return
+	}
+	pairs, err = p.MysqlLogger.FilterUnjournaled(pairs)
+	if err != nil {
+		log.Printf("Восстановление: не удалось сверить api_requests со счетами: %v", err)
+		return
+	}
+	for _, pair := range pairs {
+		p.flagApiRequest(pair)
+	}
+
+	if len(orphans) > 0 || len(pairs) > 0 {
+		log.Printf("Восстановление: разобрано %d заявок из журнала, %d из api_requests", len(orphans), len(pairs))
+	}
+}
+
+// recoverOrphan привязывает живую заявку к счету без реквизитов, лишнюю отменяет,
+// остальное передаёт оператору
+func (p *Processor) recoverOrphan(orphan models.OrphanOrder, now time.Time) {
+	attempt := orphan.Attempt
+	ex := orphan.Exchanger
+
+	if attempt.State == models.OrderCreating {
+		details, found, err := p.findOrder(ex, attempt.InvoiceID)
+		if err != nil {
+			p.flagOrphan(orphan, err.Error())
+			return
+		}
+		if !found {
+			if err := p.MysqlLogger.SetOrderAttemptState(attempt.InvoiceID, ex.ID, models.OrderFailed); err != nil {
+				log.Printf("Восстановление: не удалось обновить журнал счета %d: %v", attempt.InvoiceID, err)
+				return
+			}
+			p.ClickLogger.LogOrphanOrder(attempt.InvoiceID, ex.ID, "", sourceJournal, recoveryNotFound, "")
+			return
+		}
+		attempt.State = models.OrderCreated
+		attempt.ExternalID = details.ID
+		attempt.Details = &details
+		if err := p.MysqlLogger.SaveOrderAttempt(attempt); err != nil {
+			log.Printf("Восстановление: не удалось обновить журнал счета %d: %v", attempt.InvoiceID, err)
+			return
+		}
+	}
+
+	if attempt.Details == nil {
+		p.flagOrphan(orphan, "в журнале нет реквизитов")
+		return
+	}
+
+	// Счет ещё ждёт реквизиты - выдаём ему найденную заявку
+	if orphan.InvoiceStatus.Normalize() == models.StatusSearch && orphan.InvoiceExchangerID == 0 && orderLive(*attempt.Details, attempt.UpdatedAt, now) {
+		task := models.InvoiceTask{Invoice: models.Invoice{ID: attempt.InvoiceID, ServiceID: orphan.ServiceID}}
+		err := p.SuccessGetRequisites(task, ex, *attempt.Details)
+		if err == nil {
+			p.ClickLogger.LogOrphanOrder(attempt.InvoiceID, ex.ID, attempt.ExternalID, sourceJournal, recoveryAttached, "")
+			return
+		}
+		var transitionErr *models.TransitionError
+		if errors.As(err, &transitionErr) {
+			// Счет закрылся одновременно с нами, SuccessGetRequisites уже отменил заявку
+			return
+		}
+		log.Printf("Восстановление: не удалось привязать заявку к счету %d: %v", attempt.InvoiceID, err)
+		return
+	}
+
+	if p.cancelOrder(ex, attempt.InvoiceID, attempt.ExternalID, "recovery") {
+		p.ClickLogger.LogOrphanOrder(attempt.InvoiceID, ex.ID, attempt.ExternalID, sourceJournal, recoveryCancelled, "")
+		return
+	}
+	p.flagOrphan(orphan, "не удалось отменить заявку у обменника")
+}
+
+// findOrder ищет заявку у обменника по ID счета
+func (p *Processor) findOrder(ex models.Exchanger, invoiceID uint64) (models.DetailsRequisites, bool, error) {
+	exchanger, err := p.newExchanger(ex, CapCreateOrder)
+	if err != nil {
+		return models.DetailsRequisites{}, false, err
+	}
+	finder, ok := exchanger.(OrderFinder)
+	if !ok {
+		return models.DetailsRequisites{}, false, errOrderUncertain
+	}
+	return finder.FindOrder(invoiceID)
+}
+
+// flagOrphan помечает заявку для оператора, повторно она не разбирается
+func (p *Processor) flagOrphan(orphan models.OrphanOrder, reason string) {
+	attempt := orphan.Attempt
+	if err := p.MysqlLogger.SetOrderAttemptState(attempt.InvoiceID, attempt.ExchangerID, models.OrderOrphaned); err != nil {
+		log.Printf("Восстановление: не удалось обновить журнал счета %d: %v", attempt.InvoiceID, err)
+		return
+	}
+	log.Printf("Восстановление: заявка счета %d в %s требует оператора: %s", attempt.InvoiceID, orphan.Exchanger.Name, reason)
+	p.ClickLogger.LogOrphanOrder(attempt.InvoiceID, attempt.ExchangerID, attempt.ExternalID, sourceJournal, recoveryFlagged, reason)
+}
+
+// flagApiRequest передаёт оператору успешный запрос к обменнику, которого нет в журнале.
+// Ответы обменников различаются, поэтому разобрать заявку из api_requests автоматически нельзя
+func (p *Processor) flagApiRequest(pair models.ApiRequestPair) {
+	err := p.MysqlLogger.SaveOrderAttempt(models.OrderAttempt{
+		InvoiceID:   pair.InvoiceID,
+		ExchangerID: pair.ExchangerID,
+		State:       models.OrderOrphaned,
+		Error:       "найдено в api_requests: " + pair.Endpoint,
+	})
+	if err != nil {
+		log.Printf("Восстановление: не удалось записать журнал счета %d: %v", pair.InvoiceID, err)
+		return
+	}
+	log.Printf("Восстановление: запрос счета %d к обменнику %d без журнала, требуется оператор", pair.InvoiceID, pair.ExchangerID)
+	p.ClickLogger.LogOrphanOrder(pair.InvoiceID, pair.ExchangerID, "", sourceApiRequests, recoveryFlagged, pair.Response)
+}
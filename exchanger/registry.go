@@ -0,0 +1,88 @@
+package exchanger
+
+import (
+	"fmt"
+	"payment-service-go/models"
+	"sort"
+	"sync"
+)
+
+// Capability - возможность, которую обменник объявляет при регистрации
+type Capability uint8
+
+const (
+	CapCreateOrder Capability = 1 << iota // создание заявки и выдача реквизитов
+	CapCheckStatus                        // проверка статусов через CheckInvoices
+	CapCallback                           // приём callback-уведомлений от обменника
+	CapCancelOrder                        // отмена заявки на стороне обменника
+	CapBatchCheck                         // CheckInvoices проверяет все счета одним запросом
+	CapSyncCheck                          // CheckInvoices постранично синхронизирует все счета сервиса и сам расходует лимит запросов
+)
+
+// Factory создаёт обменник из конфигурации задачи
+type Factory func(config models.Exchanger, processor *Processor) Exchanger
+
+// Registration - запись реестра обменников
+type Registration struct {
+	Name         string
+	Capabilities Capability
+	Factory      Factory
+}
+
+// Can сообщает, поддерживает ли обменник все переданные возможности
+func (r Registration) Can(c Capability) bool {
+	return r.Capabilities&c == c
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Registration)
+)
+
+// Register добавляет обменник в реестр. Вызывается из init() файла обменника
+func Register(name string, capabilities Capability, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || factory == nil {
+		panic("exchanger: пустое имя или фабрика при регистрации")
+	}
+	if _, exists := registry[name]; exists {
+		panic("exchanger: обменник " + name + " уже зарегистрирован")
+	}
+	registry[name] = Registration{Name: name, Capabilities: capabilities, Factory: factory}
+}
+
+// Lookup возвращает запись реестра по имени обменника
+func Lookup(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	reg, ok := registry[name]
+	return reg, ok
+}
+
+// Registered возвращает имена всех зарегистрированных обменников
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newExchanger создаёт обменник из реестра, если он поддерживает нужную возможность
+func (p *Processor) newExchanger(config models.Exchanger, capability Capability) (Exchanger, error) {
+	reg, ok := Lookup(config.Name)
+	if !ok {
+		return nil, fmt.Errorf("обменник %s не поддерживается", config.Name)
+	}
+	if !reg.Can(capability) {
+		return nil, fmt.Errorf("обменник %s не поддерживает операцию", config.Name)
+	}
+	return reg.Factory(config, p), nil
+}
@@ -0,0 +1,776 @@
+package exchanger
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"payment-service-go/models"
+	"payment-service-go/redact"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RestDefinition - декларативное описание REST-обменника.
+// Загружается из JSON-файла, код на Go для подключения не нужен
+type RestDefinition struct {
+	Name         string           `json:"name"`
+	Capabilities []string         `json:"capabilities"` // create, check, callback, cancel
+	Auth         RestAuth         `json:"auth"`
+	Create       RestEndpoint     `json:"create"`
+	Response     RestResponse     `json:"response"`
+	Check        *RestStatusCheck `json:"check"`
+	Cancel       *RestEndpoint    `json:"cancel"`   // отмена заявки, поддерживает {{external_id}} и {{invoice_id}}
+	Callback     *RestCallback    `json:"callback"` // уведомления о статусе заявки
+}
+
+// RestAuth - схема авторизации запросов
+type RestAuth struct {
+	Scheme          string `json:"scheme"`           // header, bearer, query, hmac_sha512, hmac_sha256
+	Header          string `json:"header"`           // заголовок с API-ключом (header, hmac_*)
+	Param           string `json:"param"`            // параметр запроса с API-ключом (query)
+	SignatureHeader string `json:"signature_header"` // заголовок с подписью тела или query string (hmac_*)
+}
+
+// RestEndpoint - описание одного запроса к обменнику
+type RestEndpoint struct {
+	Method   string                 `json:"method"`
+	Path     string                 `json:"path"`
+	Encoding string                 `json:"encoding"` // json (по умолчанию) или query
+	Body     map[string]interface{} `json:"body"`     // шаблон тела, поддерживает {{переменные}}
+	Headers  map[string]string      `json:"headers"`
+
+	SuccessPath  string      `json:"success_path"`  // поле с признаком успеха
+	SuccessValue interface{} `json:"success_value"` // ожидаемое значение признака
+	ErrorPath    string      `json:"error_path"`    // поле с текстом ошибки
+}
+
+// RestResponse - пути к полям реквизитов в ответе на создание заявки
+type RestResponse struct {
+	Root          string `json:"root"` // путь к объекту заявки, например items.0
+	ID            string `json:"id"`
+	Requisites    string `json:"requisites"`
+	Amount        string `json:"amount"`
+	UntilAt       string `json:"until_at"`
+	UntilAtFormat string `json:"until_at_format"` // unix или layout Go; пусто - строка как есть
+	TTLMinutes    int    `json:"ttl_minutes"`     // срок жизни, если until_at не задан
+}
+
+// RestStatusCheck - описание проверки статусов
+type RestStatusCheck struct {
+	RestEndpoint
+	Batch      bool              `json:"batch"`       // один запрос на все {{external_ids}}
+	ItemsPath  string            `json:"items_path"`  // путь к списку заявок (для batch)
+	IDPath     string            `json:"id_path"`     // путь к внешнему ID внутри заявки (для batch)
+	StatusPath string            `json:"status_path"` // путь к статусу
+	StatusMap  map[string]string `json:"status_map"`  // статус обменника -> статус счета, пусто - ожидание
+}
+
+// RestCallback - описание уведомления обменника. Статус переводится по check.status_map,
+// подпись - HMAC тела на SecretKey в hex
+type RestCallback struct {
+	IDPath          string `json:"id_path"`          // путь к внешнему ID заявки
+	StatusPath      string `json:"status_path"`      // путь к статусу
+	Signature       string `json:"signature"`        // hmac_sha512 или hmac_sha256
+	SignatureHeader string `json:"signature_header"` // заголовок с подписью
+}
+
+var restCapabilities = map[string]Capability{
+	"create":   CapCreateOrder,
+	"check":    CapCheckStatus,
+	"callback": CapCallback,
+	"cancel":   CapCancelOrder,
+}
+
+// LoadRestDefinitions читает *.json из каталога и регистрирует обменники в реестре
+func LoadRestDefinitions(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var def RestDefinition
+		if err := json.Unmarshal(raw, &def); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if err := def.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+
+		var caps Capability
+		for _, name := range def.Capabilities {
+			caps |= restCapabilities[name]
+		}
+		if def.Check != nil && def.Check.Batch {
+			caps |= CapBatchCheck
+		}
+		if _, exists := Lookup(def.Name); exists {
+			return fmt.Errorf("%s: обменник %s уже зарегистрирован", file, def.Name)
+		}
+
+		definition := def
+		Register(def.Name, caps, func(config models.Exchanger, processor *Processor) Exchanger {
+			return NewRestExchanger(definition, config, processor)
+		})
+		log.Printf("REST-обменник %s загружен из %s", def.Name, file)
+	}
+
+	return nil
+}
+
+func (d *RestDefinition) Validate() error {
+	if d.Name == "" {
+		return errors.New("пустое имя обменника")
+	}
+	for _, name := range d.Capabilities {
+		if _, ok := restCapabilities[name]; !ok {
+			return fmt.Errorf("неизвестная возможность %q", name)
+		}
+	}
+	if d.Create.Path == "" {
+		return errors.New("не задан create.path")
+	}
+	if d.Response.ID == "" || d.Response.Requisites == "" {
+		return errors.New("не заданы response.id или response.requisites")
+	}
+	if d.Check != nil {
+		if d.Check.Path == "" || d.Check.StatusPath == "" {
+			return errors.New("не заданы check.path или check.status_path")
+		}
+		if d.Check.Batch && (d.Check.ItemsPath == "" || d.Check.IDPath == "") {
+			return errors.New("для batch-проверки нужны check.items_path и check.id_path")
+		}
+		for orderStatus, status := range d.Check.StatusMap {
+			if status != "" && !models.InvoiceStatus(status).IsKnown() {
+				return fmt.Errorf("status_map: неизвестный статус счета %q для %q", status, orderStatus)
+			}
+		}
+	}
+	for _, name := range d.Capabilities {
+		if name == "cancel" && (d.Cancel == nil || d.Cancel.Path == "") {
+			return errors.New("для возможности cancel нужен cancel.path")
+		}
+		if name == "callback" {
+			if err := d.validateCallback(); err != nil {
+				return err
+			}
+		}
+	}
+	// Без заголовка или параметра запросы уйдут без ключа, и ответы 401 разомкнут автомат
+	switch d.Auth.Scheme {
+	case "", "bearer":
+	case "header":
+		if d.Auth.Header == "" {
+			return errors.New("для схемы header нужен auth.header")
+		}
+	case "query":
+		if d.Auth.Param == "" {
+			return errors.New("для схемы query нужен auth.param")
+		}
+	case "hmac_sha512", "hmac_sha256":
+		if d.Auth.Header == "" || d.Auth.SignatureHeader == "" {
+			return fmt.Errorf("для схемы %s нужны auth.header и auth.signature_header", d.Auth.Scheme)
+		}
+	default:
+		return fmt.Errorf("неизвестная схема авторизации %q", d.Auth.Scheme)
+	}
+	return nil
+}
+
+// validateCallback проверяет описание уведомлений: без подписи callback принимать нельзя
+func (d *RestDefinition) validateCallback() error {
+	if d.Callback == nil || d.Callback.IDPath == "" || d.Callback.StatusPath == "" {
+		return errors.New("для возможности callback нужны callback.id_path и callback.status_path")
+	}
+	if d.Check == nil || len(d.Check.StatusMap) == 0 {
+		return errors.New("для возможности callback нужен check.status_map")
+	}
+	if d.Callback.Signature != "hmac_sha512" && d.Callback.Signature != "hmac_sha256" {
+		return fmt.Errorf("неизвестная подпись callback %q", d.Callback.Signature)
+	}
+	if d.Callback.SignatureHeader == "" {
+		return errors.New("не задан callback.signature_header")
+	}
+	return nil
+}
+
+type RestExchanger struct {
+	def       RestDefinition
+	config    models.Exchanger
+	processor *Processor
+}
+
+func NewRestExchanger(def RestDefinition, config models.Exchanger, processor *Processor) *RestExchanger {
+	return &RestExchanger{def: def, config: config, processor: processor}
+}
+
+func (r *RestExchanger) GetRequisites(task models.InvoiceTask, ex models.Exchanger) (models.DetailsRequisites, error) {
+	vars := map[string]interface{}{
+		"invoice_id": fmt.Sprintf("%d", task.Invoice.ID),
+		"amount":     ex.Amount,
+		"callback":   ex.Callback,
+		"api_key":    ex.APIKey,
+		"secret_key": ex.SecretKey,
+	}
+
+	body, err := r.request(r.def.Create, ex, vars, task.Invoice.ID)
+	if err != nil {
+		return models.DetailsRequisites{}, err
+	}
+
+	result, err := r.decode(r.def.Create, body)
+	if err != nil {
+		return models.DetailsRequisites{}, err
+	}
+
+	return r.ReturnFormattedDetails(result)
+}
+
+func (r *RestExchanger) ReturnFormattedDetails(data map[string]interface{}) (models.DetailsRequisites, error) {
+	spec := r.def.Response
+
+	var order interface{} = data
+	if spec.Root != "" {
+		var ok bool
+		order, ok = lookupPath(data, spec.Root)
+		if !ok {
+			return models.DetailsRequisites{}, fmt.Errorf("не удалось получить '%s'", spec.Root)
+		}
+	}
+
+	id, ok := lookupString(order, spec.ID)
+	if !ok || id == "" {
+		return models.DetailsRequisites{}, fmt.Errorf("не удалось получить '%s'", spec.ID)
+	}
+
+	requisites, ok := lookupString(order, spec.Requisites)
+	if !ok || requisites == "" {
+		return models.DetailsRequisites{}, fmt.Errorf("не удалось получить '%s'", spec.Requisites)
+	}
+
+	amountIn := r.config.Amount
+	if spec.Amount != "" {
+		amountIn, ok = lookupFloat(order, spec.Amount)
+		if !ok {
+			return models.DetailsRequisites{}, fmt.Errorf("не удалось получить '%s'", spec.Amount)
+		}
+	}
+
+	untilAt, err := r.untilAt(order)
+	if err != nil {
+		return models.DetailsRequisites{}, err
+	}
+
+	details, ok := order.(map[string]interface{})
+	if !ok {
+		details = data
+	}
+
+	return models.DetailsRequisites{
+		ID:         id,
+		AmountIn:   amountIn,
+		UntilAt:    untilAt,
+		Requisites: requisites,
+		Details:    details,
+	}, nil
+}
+
+func (r *RestExchanger) untilAt(order interface{}) (string, error) {
+	spec := r.def.Response
+	if spec.UntilAt == "" {
+		ttl := spec.TTLMinutes
+		if ttl <= 0 {
+			ttl = 20
+		}
+		return time.Now().UTC().Add(time.Duration(ttl) * time.Minute).Format("2006-01-02 15:04:05"), nil
+	}
+
+	switch spec.UntilAtFormat {
+	case "unix":
+		unix, ok := lookupFloat(order, spec.UntilAt)
+		if !ok {
+			return "", fmt.Errorf("не удалось получить '%s'", spec.UntilAt)
+		}
+		return time.Unix(int64(unix), 0).UTC().Format("2006-01-02 15:04:05"), nil
+	case "":
+		raw, ok := lookupString(order, spec.UntilAt)
+		if !ok {
+			return "", fmt.Errorf("не удалось получить '%s'", spec.UntilAt)
+		}
+		return raw, nil
+	default:
+		raw, ok := lookupString(order, spec.UntilAt)
+		if !ok {
+			return "", fmt.Errorf("не удалось получить '%s'", spec.UntilAt)
+		}
+		parsed, err := time.Parse(spec.UntilAtFormat, raw)
+		if err != nil {
+			return "", fmt.Errorf("не удалось разобрать '%s': %v", spec.UntilAt, err)
+		}
+		return parsed.UTC().Format("2006-01-02 15:04:05"), nil
+	}
+}
+
+func (r *RestExchanger) CheckInvoices(invoices []models.InvoiceCheckLite, serviceID uint64) error {
+	check := r.def.Check
+	if check == nil {
+		return fmt.Errorf("[%s] проверка статусов не описана", r.def.Name)
+	}
+
+	// Ошибки по счетам копятся, чтобы автомат и метрики видели недоступность обменника
+	var errs []error
+	if !check.Batch {
+		for _, invoice := range invoices {
+			vars := r.baseVars()
+			vars["invoice_id"] = fmt.Sprintf("%d", invoice.ID)
+			vars["external_id"] = invoice.ExternalID
+
+			body, err := r.request(check.RestEndpoint, r.config, vars, invoice.ID)
+			if err != nil {
+				r.processor.ClickLogger.LogErrorApiRequests(invoice.ID, r.config.ID, err.Error())
+				log.Printf("[%s] не удалось проверить счет InvoiceID: %v, error: %v", r.def.Name, invoice.ID, err)
+				errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
+				continue
+			}
+
+			result, err := r.decode(check.RestEndpoint, body)
+			if err != nil {
+				log.Printf("[%s] не удалось проверить счет InvoiceID: %v, error: %v", r.def.Name, invoice.ID, err)
+				errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
+				continue
+			}
+
+			orderStatus, ok := lookupString(result, check.StatusPath)
+			if !ok {
+				log.Printf("[%s] не удалось получить статус у InvoiceID: %v", r.def.Name, invoice.ID)
+				errs = append(errs, fmt.Errorf("счет %d: не удалось получить '%s'", invoice.ID, check.StatusPath))
+				continue
+			}
+
+			if err := r.applyStatus(invoice, orderStatus); err != nil {
+				log.Printf("[%s] не удалось обработать статус у InvoiceID: %v, error: %v", r.def.Name, invoice.ID, err)
+				errs = append(errs, fmt.Errorf("счет %d: %w", invoice.ID, err))
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("[%s] %w", r.def.Name, errors.Join(errs...))
+		}
+		return nil
+	}
+
+	externalIDs := make([]string, 0, len(invoices))
+	byExternalID := make(map[string]models.InvoiceCheckLite, len(invoices))
+	for _, inv := range invoices {
+		externalIDs = append(externalIDs, inv.ExternalID)
+		byExternalID[inv.ExternalID] = inv
+	}
+
+	ids := make([]uint64, 0, len(invoices))
+	for _, inv := range invoices {
+		ids = append(ids, inv.ID)
+	}
+
+	vars := r.baseVars()
+	vars["external_ids"] = externalIDs
+
+	body, err := r.request(check.RestEndpoint, r.config, vars, ids...)
+	if err != nil {
+		return fmt.Errorf("[%s] %w", r.def.Name, err)
+	}
+
+	result, err := r.decode(check.RestEndpoint, body)
+	if err != nil {
+		return fmt.Errorf("[%s] %w", r.def.Name, err)
+	}
+
+	itemsRaw, ok := lookupPath(result, check.ItemsPath)
+	if !ok {
+		return fmt.Errorf("[%s] не удалось получить '%s'", r.def.Name, check.ItemsPath)
+	}
+	items, ok := itemsRaw.([]interface{})
+	if !ok {
+		return fmt.Errorf("[%s] '%s' не является списком", r.def.Name, check.ItemsPath)
+	}
+
+	for _, item := range items {
+		externalID, ok := lookupString(item, check.IDPath)
+		if !ok {
+			log.Printf("[%s] не удалось получить '%s'", r.def.Name, check.IDPath)
+			continue
+		}
+		invoice, ok := byExternalID[externalID]
+		if !ok {
+			continue
+		}
+		orderStatus, ok := lookupString(item, check.StatusPath)
+		if !ok {
+			log.Printf("[%s] не удалось получить статус у ExternalID: %v", r.def.Name, externalID)
+			errs = append(errs, fmt.Errorf("заявка %s: не удалось получить '%s'", externalID, check.StatusPath))
+			continue
+		}
+		if err := r.applyStatus(invoice, orderStatus); err != nil {
+			log.Printf("[%s] не удалось обработать статус у ExternalID: %v, error: %v", r.def.Name, externalID, err)
+			errs = append(errs, fmt.Errorf("заявка %s: %w", externalID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("[%s] %w", r.def.Name, errors.Join(errs...))
+	}
+	return nil
+}
+
+// applyStatus применяет статус заявки к счету. Отклонённый переход уже записан и не говорит о сбое обменника
+func (r *RestExchanger) applyStatus(invoice models.InvoiceCheckLite, orderStatus string) error {
+	err := r.processor.applyOrderStatus(invoice, orderStatus, r.mapStatus)
+	var transitionErr *models.TransitionError
+	if errors.As(err, &transitionErr) {
+		return nil
+	}
+	return err
+}
+
+// CancelOrder отменяет заявку по описанию cancel
+func (r *RestExchanger) CancelOrder(invoiceID uint64, externalID string) error {
+	if r.def.Cancel == nil {
+		return fmt.Errorf("[%s] отмена заявки не описана", r.def.Name)
+	}
+
+	vars := r.baseVars()
+	vars["invoice_id"] = fmt.Sprintf("%d", invoiceID)
+	vars["external_id"] = externalID
+
+	body, err := r.request(*r.def.Cancel, r.config, vars, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.decode(*r.def.Cancel, body)
+	return err
+}
+
+// ParseCallback достаёт ID заявки и статус по путям callback.id_path и callback.status_path
+func (r *RestExchanger) ParseCallback(body []byte) (string, string, error) {
+	if r.def.Callback == nil {
+		return "", "", fmt.Errorf("[%s] callback не описан", r.def.Name)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", "", err
+	}
+
+	externalID, ok := lookupString(result, r.def.Callback.IDPath)
+	if !ok || externalID == "" {
+		return "", "", fmt.Errorf("не удалось получить '%s'", r.def.Callback.IDPath)
+	}
+	status, ok := lookupString(result, r.def.Callback.StatusPath)
+	if !ok {
+		return "", "", fmt.Errorf("не удалось получить '%s'", r.def.Callback.StatusPath)
+	}
+	return externalID, status, nil
+}
+
+// VerifyCallback сверяет подпись из callback.signature_header с HMAC тела на SecretKey
+func (r *RestExchanger) VerifyCallback(header http.Header, body []byte) error {
+	if r.def.Callback == nil {
+		return fmt.Errorf("[%s] callback не описан", r.def.Name)
+	}
+
+	expected := sign(r.def.Callback.Signature, r.config.SecretKey, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(header.Get(r.def.Callback.SignatureHeader)))) {
+		return fmt.Errorf("%s не совпадает", r.def.Callback.SignatureHeader)
+	}
+	return nil
+}
+
+// mapStatus переводит статус обменника в статус счета по status_map
+func (r *RestExchanger) mapStatus(orderStatus string) (models.InvoiceStatus, error) {
+	if r.def.Check == nil {
+		return "", errors.New("Не получилось обработать статус")
+	}
+	status, ok := r.def.Check.StatusMap[orderStatus]
+	if !ok {
+		return "", errors.New("Не получилось обработать статус")
+	}
+	return models.InvoiceStatus(status), nil
+}
+
+func (r *RestExchanger) baseVars() map[string]interface{} {
+	return map[string]interface{}{
+		"amount":     r.config.Amount,
+		"callback":   r.config.Callback,
+		"api_key":    r.config.APIKey,
+		"secret_key": r.config.SecretKey,
+	}
+}
+
+// do выполняет запрос по описанию и возвращает url, код ответа, тело и отправленные параметры.
+// url и параметры - для журнала запросов, ключ в них маскирован
+func (r *RestExchanger) do(endpoint RestEndpoint, ex models.Exchanger, vars map[string]interface{}) (string, int, []byte, string, error) {
+	method := endpoint.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	fields := renderTemplate(endpoint.Body, vars).(map[string]interface{})
+	urlApi := ex.Endpoint + renderString(endpoint.Path, vars)
+
+	// Ключ в query string передаётся при любой кодировке тела
+	query := url.Values{}
+	if r.def.Auth.Scheme == "query" {
+		query.Set(r.def.Auth.Param, ex.APIKey)
+	}
+
+	var reqBody []byte
+	if endpoint.Encoding == "query" {
+		for key, value := range fields {
+			query.Set(key, stringify(value))
+		}
+	} else if len(fields) > 0 {
+		var err error
+		reqBody, err = json.Marshal(fields)
+		if err != nil {
+			return "", 0, nil, "", err
+		}
+	}
+
+	// Encode сортирует параметры по имени, эта же строка подписывается для кодировки query.
+	// В журнал запросов ключ попадает маскированным
+	rawQuery := query.Encode()
+	loggedQuery := rawQuery
+	if r.def.Auth.Scheme == "query" {
+		masked := url.Values{}
+		for key, values := range query {
+			masked[key] = values
+		}
+		masked.Set(r.def.Auth.Param, redact.Default().Mask(ex.APIKey))
+		loggedQuery = masked.Encode()
+	}
+	params := string(reqBody)
+	if endpoint.Encoding == "query" {
+		params = loggedQuery
+	}
+	requestURL := urlApi
+	if rawQuery != "" {
+		requestURL += "?" + rawQuery
+		urlApi += "?" + loggedQuery
+	}
+
+	req, err := http.NewRequest(method, requestURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return "", 0, nil, "", err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range endpoint.Headers {
+		req.Header.Set(key, renderString(value, vars))
+	}
+
+	switch r.def.Auth.Scheme {
+	case "header":
+		req.Header.Set(r.def.Auth.Header, ex.APIKey)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+ex.APIKey)
+	case "hmac_sha512", "hmac_sha256":
+		// Подписывается тело, а при кодировке query - отсортированная query string
+		signed := reqBody
+		if endpoint.Encoding == "query" {
+			signed = []byte(rawQuery)
+		}
+		req.Header.Set(r.def.Auth.Header, ex.APIKey)
+		req.Header.Set(r.def.Auth.SignatureHeader, sign(r.def.Auth.Scheme, ex.SecretKey, signed))
+	}
+
+	client := &http.Client{Timeout: 8 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return urlApi, 0, nil, params, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return urlApi, resp.StatusCode, nil, params, err
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return urlApi, resp.StatusCode, body, params, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	return urlApi, resp.StatusCode, body, params, nil
+}
+
+// request выполняет запрос и пишет в api_requests каждый полученный ответ, в том числе с кодом ошибки
+func (r *RestExchanger) request(endpoint RestEndpoint, ex models.Exchanger, vars map[string]interface{}, invoiceIDs ...uint64) ([]byte, error) {
+	urlApi, status, body, params, err := r.do(endpoint, ex, vars)
+	if status != 0 {
+		for _, id := range invoiceIDs {
+			r.processor.logApiRequest(ex, urlApi, status, string(body), params, id)
+		}
+	}
+	return body, err
+}
+
+// sign - HMAC данных на ключе в hex, scheme - hmac_sha512 или hmac_sha256
+func sign(scheme, key string, data []byte) string {
+	var h hash.Hash
+	if scheme == "hmac_sha512" {
+		h = hmac.New(sha512.New, []byte(key))
+	} else {
+		h = hmac.New(sha256.New, []byte(key))
+	}
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// decode разбирает JSON-ответ и проверяет признак успеха
+func (r *RestExchanger) decode(endpoint RestEndpoint, body []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	if endpoint.SuccessPath == "" {
+		return result, nil
+	}
+
+	value, _ := lookupPath(result, endpoint.SuccessPath)
+	if stringify(value) != stringify(endpoint.SuccessValue) {
+		if msg, ok := lookupString(result, endpoint.ErrorPath); ok && endpoint.ErrorPath != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, errors.New("неизвестная ошибка от сервера")
+	}
+
+	return result, nil
+}
+
+// renderTemplate подставляет переменные в шаблон тела.
+// Значение вида "{{name}}" целиком заменяется переменной с сохранением типа
+func renderTemplate(tmpl interface{}, vars map[string]interface{}) interface{} {
+	switch v := tmpl.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			out[key] = renderTemplate(value, vars)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = renderTemplate(value, vars)
+		}
+		return out
+	case string:
+		if strings.HasPrefix(v, "{{") && strings.HasSuffix(v, "}}") && strings.Count(v, "{{") == 1 {
+			if value, ok := vars[strings.TrimSpace(v[2:len(v)-2])]; ok {
+				return value
+			}
+		}
+		return renderString(v, vars)
+	default:
+		return v
+	}
+}
+
+func renderString(s string, vars map[string]interface{}) string {
+	for key, value := range vars {
+		s = strings.ReplaceAll(s, "{{"+key+"}}", stringify(value))
+	}
+	return s
+}
+
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// lookupPath достаёт значение по пути вида "items.0.order_id"
+func lookupPath(data interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return data, true
+	}
+
+	current := data
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func lookupString(data interface{}, path string) (string, bool) {
+	value, ok := lookupPath(data, path)
+	if !ok || value == nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string, float64, bool:
+		return stringify(v), true
+	default:
+		return "", false
+	}
+}
+
+func lookupFloat(data interface{}, path string) (float64, bool) {
+	value, ok := lookupPath(data, path)
+	if !ok {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
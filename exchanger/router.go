@@ -0,0 +1,111 @@
+package exchanger
+
+import (
+	"log"
+	"payment-service-go/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyReferenceMs - задержка, при которой обменник теряет четверть оценки
+const latencyReferenceMs = 2000.0
+
+// Router переупорядочивает обменники задачи по весам и истории из ClickHouse
+type Router struct {
+	config RoutingConfig
+	click  AuditLog
+
+	mu        sync.RWMutex
+	analytics map[string]models.ExchangerStats
+	apiCounts map[uint32]models.ExchangerApiCounts
+}
+
+func NewRouter(config RoutingConfig, click AuditLog) *Router {
+	return &Router{
+		config:    config,
+		click:     click,
+		analytics: make(map[string]models.ExchangerStats),
+		apiCounts: make(map[uint32]models.ExchangerApiCounts),
+	}
+}
+
+// Run периодически обновляет статистику, пока не закрыт stop
+func (r *Router) Run(stop <-chan struct{}) {
+	r.refresh()
+
+	ticker := time.NewTicker(r.config.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.refresh()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *Router) refresh() {
+	since := time.Now().Add(-r.config.Window)
+
+	analytics, err := r.click.AnalyticsByExchanger(since)
+	if err != nil {
+		log.Printf("Маршрутизация: не удалось получить exchangers_analytics: %v", err)
+		return
+	}
+	apiCounts, err := r.click.ApiCountsByExchanger(since)
+	if err != nil {
+		log.Printf("Маршрутизация: не удалось получить api_requests: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	r.analytics = analytics
+	r.apiCounts = apiCounts
+	r.mu.Unlock()
+}
+
+// Order возвращает обменники задачи по убыванию оценки.
+// Обменники с нулевым статическим весом исключаются, при равной оценке сохраняется порядок продюсера
+func (r *Router) Order(task models.InvoiceTask) []models.Exchanger {
+	type scored struct {
+		ex    models.Exchanger
+		score float64
+	}
+
+	candidates := make([]scored, 0, len(task.Exchangers))
+	for _, ex := range task.Exchangers {
+		weight := r.config.weight(task.Invoice.ServiceID, ex.ID)
+		if weight <= 0 {
+			log.Printf("Маршрутизация: обменник %s отключён весом для счета %d", ex.Name, task.Invoice.ID)
+			continue
+		}
+		candidates = append(candidates, scored{ex: ex, score: weight * r.Score(ex)})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	ordered := make([]models.Exchanger, len(candidates))
+	for i, c := range candidates {
+		ordered[i] = c.ex
+	}
+	return ordered
+}
+
+// Score - оценка обменника от 0 до 1 по успешности, задержке и доле ошибок API
+func (r *Router) Score(ex models.Exchanger) float64 {
+	r.mu.RLock()
+	stats := r.analytics[ex.Name]
+	counts := r.apiCounts[ex.ID]
+	r.mu.RUnlock()
+
+	// Сглаживание Лапласа: обменник без истории получает 0.5
+	successRate := (float64(stats.Successes) + 1) / (float64(stats.Attempts) + 2)
+	latency := 1 / (1 + stats.AvgLatencyMs/latencyReferenceMs)
+	errorRate := (float64(counts.Errors) + 1) / (float64(counts.Errors+counts.Requests) + 2)
+
+	return successRate * (0.5 + 0.5*latency) * (1 - 0.5*errorRate)
+}
@@ -0,0 +1,67 @@
+package exchanger
+
+import (
+	"errors"
+	"log"
+	"payment-service-go/metrics"
+	"payment-service-go/models"
+	"time"
+)
+
+// EventPublisher - получатель событий жизненного цикла счета
+type EventPublisher interface {
+	Publish(event models.InvoiceEvent) error
+}
+
+// ChangeInvoiceStatus меняет статус счета в MySQL и в той же транзакции кладёт событие в outbox.
+// История в ClickHouse и событие в RabbitMQ доставляются OutboxRelay.
+// Запрещённый переход не применяется, пишется в invoice_rejected_transitions и возвращается как *models.TransitionError
+func (p *Processor) ChangeInvoiceStatus(invoice models.InvoiceCheckLite, status models.InvoiceStatus, updatedBy string, details *string) error {
+	event := newInvoiceEvent(models.StatusEventType(string(status)), invoice.ID, string(status), updatedBy)
+	event.ExternalID = invoice.ExternalID
+	if details != nil {
+		event.Data = map[string]interface{}{"details": *details}
+	}
+
+	err := p.MysqlLogger.UpdateInvoiceStatus(invoice, status, event)
+	var transitionErr *models.TransitionError
+	if errors.As(err, &transitionErr) {
+		p.rejectTransition(transitionErr, updatedBy, details)
+	} else if err == nil {
+		recordTransition(status, updatedBy, metrics.TransitionApplied)
+	}
+	return err
+}
+
+// rejectTransition фиксирует отклонённый переход статуса
+func (p *Processor) rejectTransition(transitionErr *models.TransitionError, updatedBy string, details *string) {
+	log.Printf("Отклонён переход статуса: %v", transitionErr)
+	recordTransition(transitionErr.To, updatedBy, metrics.TransitionRejected)
+	p.ClickLogger.LogRejectedTransition(transitionErr.InvoiceID, transitionErr.From, string(transitionErr.To), updatedBy, details)
+}
+
+// applyOrderStatus переводит счет в статус, соответствующий статусу заявки у обменника
+func (p *Processor) applyOrderStatus(invoice models.InvoiceCheckLite, orderStatus string, mapStatus func(string) (models.InvoiceStatus, error)) error {
+	status, err := mapStatus(orderStatus)
+	if err != nil {
+		return err
+	}
+	if status == models.StatusSearch {
+		return nil
+	}
+
+	details := "OrderStatus: " + orderStatus
+	return p.ChangeInvoiceStatus(invoice, status, "golang_process_status", &details)
+}
+
+func newInvoiceEvent(eventType string, invoiceID uint64, status, updatedBy string) models.InvoiceEvent {
+	return models.InvoiceEvent{
+		ID:         models.NewEventID(),
+		Version:    models.InvoiceEventVersion,
+		Type:       eventType,
+		InvoiceID:  invoiceID,
+		Status:     status,
+		UpdatedBy:  updatedBy,
+		OccurredAt: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,61 @@
+package exchanger
+
+import (
+	"errors"
+	"payment-service-go/memory"
+	"payment-service-go/models"
+	"testing"
+)
+
+func TestChangeInvoiceStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     models.InvoiceStatus
+		to       models.InvoiceStatus
+		rejected bool
+	}{
+		{name: "pending -> paid", from: models.StatusPending, to: models.StatusPaid},
+		{name: "pending -> cancel_time", from: models.StatusPending, to: models.StatusCancelTime},
+		{name: "cancel_time -> paid", from: models.StatusCancelTime, to: models.StatusPaid},
+		{name: "paid -> cancel_time", from: models.StatusPaid, to: models.StatusCancelTime, rejected: true},
+		{name: "cancel_operator -> pending", from: models.StatusCancelOperator, to: models.StatusPending, rejected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, store, audit := newTestProcessor(t)
+			store.AddInvoice(memory.Invoice{ID: 10, ServiceID: testServiceID, ExternalID: "order-10", Status: tt.from})
+
+			details := "OrderStatus: test"
+			err := p.ChangeInvoiceStatus(models.InvoiceCheckLite{ID: 10, ExternalID: "order-10"}, tt.to, "golang_test", &details)
+
+			var transitionErr *models.TransitionError
+			if got := errors.As(err, &transitionErr); got != tt.rejected {
+				t.Fatalf("ChangeInvoiceStatus() error = %v, rejected %v", err, tt.rejected)
+			}
+			if !tt.rejected && err != nil {
+				t.Fatalf("ChangeInvoiceStatus() error = %v", err)
+			}
+
+			want, events := tt.to, 1
+			if tt.rejected {
+				want, events = tt.from, 0
+			}
+			invoice, _ := store.Invoice(10)
+			if invoice.Status != want {
+				t.Errorf("статус счета = %q, want %q", invoice.Status, want)
+			}
+			if got := len(store.Events()); got != events {
+				t.Errorf("событий в outbox = %d, want %d", got, events)
+			}
+
+			rejected := audit.Records().RejectedTransitions
+			if tt.rejected != (len(rejected) == 1) {
+				t.Fatalf("отклонённых переходов = %d", len(rejected))
+			}
+			if tt.rejected && (rejected[0].From != string(tt.from) || rejected[0].To != string(tt.to) || rejected[0].UpdatedBy != "golang_test") {
+				t.Errorf("отклонённый переход = %+v", rejected[0])
+			}
+		})
+	}
+}
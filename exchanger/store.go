@@ -0,0 +1,58 @@
+package exchanger
+
+import (
+	"payment-service-go/models"
+	"time"
+)
+
+// InvoiceStore - счета, ключи обменников, журнал заявок и outbox. В работе - *mysql.MySQLDB,
+// в тестах - memory.InvoiceStore
+type InvoiceStore interface {
+	UpdateInvoice(invoiceID uint64, exchangerId uint32, details models.DetailsRequisites, event models.InvoiceEvent) error
+	UpdateGrooupInvoicesStatus(invoices []models.InvoiceCheckLite, status models.InvoiceStatus, events []models.InvoiceEvent) ([]*models.TransitionError, error)
+	UpdateInvoiceStatus(invoice models.InvoiceCheckLite, status models.InvoiceStatus, event models.InvoiceEvent) error
+
+	GetInvoiceByExternalIDAndServiceID(externalID string, serviceID uint64) (*models.InvoiceCheckLite, error)
+	GetCallbackInvoice(exchangerName, externalID string) (*models.InvoiceCheck, error)
+	GetCallbackCredentials(exchangerName string) (map[uint64]models.Exchanger, error)
+	GetExchangerCredentials(serviceID uint64, exchangerID uint32) (*models.Exchanger, error)
+	GetInvoicesByStatus(status string, date string) ([]models.InvoiceCheck, error)
+	GetActiveInvoices(date string) ([]models.InvoiceCheck, error)
+	GetPendingInvoicesByExchanger(serviceID uint64, exchangerID uint32) ([]models.InvoiceCheckLite, error)
+
+	GetSyncMark(serviceID uint64, exchangerID uint32) (time.Time, bool, error)
+	SaveSyncMark(serviceID uint64, exchangerID uint32, until time.Time) error
+
+	GetOrderAttempt(invoiceID uint64, exchangerID uint32) (*models.OrderAttempt, error)
+	SaveOrderAttempt(attempt models.OrderAttempt) error
+	SetOrderAttemptState(invoiceID uint64, exchangerID uint32, state string) error
+	GetOrphanAttempts(since, before time.Time, limit int) ([]models.OrphanOrder, error)
+	FilterUnjournaled(pairs []models.ApiRequestPair) ([]models.ApiRequestPair, error)
+
+	FetchOutbox(limit int) ([]models.OutboxEntry, error)
+	MarkOutboxDelivered(id uint64, destination string) error
+	MarkOutboxFailed(id uint64, retryAt time.Time, lastError string) error
+	PurgeOutbox(before time.Time) error
+
+	Close()
+}
+
+// AuditLog - журналы запросов, истории счетов и аналитика. В работе - *clickhouse.ClickDB,
+// в тестах - memory.AuditLog
+type AuditLog interface {
+	ApiRequests(endpoint string, statusCode int, response string, params string, invoiceId uint64, exchangerId uint32) error
+	LogErrorApiRequests(invoiceID uint64, exchangerId uint32, errorMessage string) error
+	LogErrorInvoice(invoice models.Invoice, errorMessage string) error
+	LogAnalytics(invoiceID uint64, status, exchangerName string, duration float64, createdAt time.Time) error
+	InvoiceHistoryInsert(invoiceId uint64, updatedBy string, status string, userId *uint64, details *string, at time.Time) error
+	LogBreakerState(exchangerID uint32, exchangerName, fromState, toState, reason string) error
+	LogRejectedTransition(invoiceID uint64, fromStatus, toStatus, updatedBy string, details *string) error
+	LogOrderCancel(invoiceID uint64, exchangerID uint32, externalID, reason, status, errorText string) error
+	LogOrphanOrder(invoiceID uint64, exchangerID uint32, externalID, source, action, details string) error
+
+	SuccessfulApiRequests(since, until time.Time) ([]models.ApiRequestPair, error)
+	AnalyticsByExchanger(since time.Time) (map[string]models.ExchangerStats, error)
+	ApiCountsByExchanger(since time.Time) (map[uint32]models.ExchangerApiCounts, error)
+
+	Close()
+}
@@ -8,6 +8,12 @@ import (
 	"time"
 )
 
+func init() {
+	Register("Test", CapCreateOrder, func(config models.Exchanger, _ *Processor) Exchanger {
+		return NewTestExchanger(config)
+	})
+}
+
 type TestExchanger struct {
 	config models.Exchanger
 }
@@ -0,0 +1,334 @@
+package memory
+
+import (
+	"payment-service-go/models"
+	"payment-service-go/redact"
+	"sync"
+	"time"
+)
+
+// ApiRequest - строка api_requests
+type ApiRequest struct {
+	InvoiceID   uint64
+	ExchangerID uint32
+	StatusCode  int
+	Endpoint    string
+	Params      string
+	Response    string
+	Time        time.Time
+}
+
+// ApiError - строка api_error_requests, InvoiceID 0 - ошибка без счета
+type ApiError struct {
+	InvoiceID   uint64
+	ExchangerID uint32
+	Message     string
+	Time        time.Time
+}
+
+// InvoiceError - строка invoices_errors_logs
+type InvoiceError struct {
+	InvoiceID uint64
+	Message   string
+	Time      time.Time
+}
+
+// Attempt - строка exchangers_analytics
+type Attempt struct {
+	InvoiceID     uint64
+	Status        string
+	ExchangerName string
+	DurationMs    float64
+	CreatedAt     time.Time
+	ProcessedAt   time.Time
+}
+
+// HistoryEntry - строка invoice_history
+type HistoryEntry struct {
+	InvoiceID uint64
+	Status    string
+	UpdatedBy string
+	UserID    *uint64
+	Details   *string
+	Time      time.Time
+}
+
+// BreakerEvent - строка exchangers_breaker_events
+type BreakerEvent struct {
+	ExchangerID   uint32
+	ExchangerName string
+	From          string
+	To            string
+	Reason        string
+	Time          time.Time
+}
+
+// RejectedTransition - строка invoice_rejected_transitions
+type RejectedTransition struct {
+	InvoiceID uint64
+	From      string
+	To        string
+	UpdatedBy string
+	Details   *string
+	Time      time.Time
+}
+
+// OrderCancel - строка exchangers_order_cancellations
+type OrderCancel struct {
+	InvoiceID   uint64
+	ExchangerID uint32
+	ExternalID  string
+	Reason      string
+	Status      string
+	Error       string
+	Time        time.Time
+}
+
+// OrphanOrder - строка exchangers_orphan_orders
+type OrphanOrder struct {
+	InvoiceID   uint64
+	ExchangerID uint32
+	ExternalID  string
+	Source      string
+	Action      string
+	Details     string
+	Time        time.Time
+}
+
+// AuditRecords - копия всех записанных строк
+type AuditRecords struct {
+	ApiRequests         []ApiRequest
+	ApiErrors           []ApiError
+	InvoiceErrors       []InvoiceError
+	Attempts            []Attempt
+	History             []HistoryEntry
+	BreakerEvents       []BreakerEvent
+	RejectedTransitions []RejectedTransition
+	OrderCancels        []OrderCancel
+	OrphanOrders        []OrphanOrder
+}
+
+// AuditLog - AuditLog в памяти. Строки маскируются так же, как перед записью в ClickHouse
+type AuditLog struct {
+	mu      sync.Mutex
+	records AuditRecords
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// Records возвращает копию записанных строк
+func (a *AuditLog) Records() AuditRecords {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := a.records
+	return AuditRecords{
+		ApiRequests:         append([]ApiRequest(nil), r.ApiRequests...),
+		ApiErrors:           append([]ApiError(nil), r.ApiErrors...),
+		InvoiceErrors:       append([]InvoiceError(nil), r.InvoiceErrors...),
+		Attempts:            append([]Attempt(nil), r.Attempts...),
+		History:             append([]HistoryEntry(nil), r.History...),
+		BreakerEvents:       append([]BreakerEvent(nil), r.BreakerEvents...),
+		RejectedTransitions: append([]RejectedTransition(nil), r.RejectedTransitions...),
+		OrderCancels:        append([]OrderCancel(nil), r.OrderCancels...),
+		OrphanOrders:        append([]OrphanOrder(nil), r.OrphanOrders...),
+	}
+}
+
+func (a *AuditLog) ApiRequests(endpoint string, statusCode int, response string, params string, invoiceId uint64, exchangerId uint32) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.ApiRequests = append(a.records.ApiRequests, ApiRequest{
+		InvoiceID:   invoiceId,
+		ExchangerID: exchangerId,
+		StatusCode:  statusCode,
+		Endpoint:    redact.Default().URL("", endpoint),
+		Params:      redact.Default().Params("", params),
+		Response:    redact.Default().Body("", response),
+		Time:        time.Now(),
+	})
+	return nil
+}
+
+func (a *AuditLog) LogErrorApiRequests(invoiceID uint64, exchangerId uint32, errorMessage string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.ApiErrors = append(a.records.ApiErrors, ApiError{InvoiceID: invoiceID, ExchangerID: exchangerId, Message: redact.Default().Text(errorMessage), Time: time.Now()})
+	return nil
+}
+
+func (a *AuditLog) LogErrorInvoice(invoice models.Invoice, errorMessage string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.InvoiceErrors = append(a.records.InvoiceErrors, InvoiceError{InvoiceID: invoice.ID, Message: redact.Default().Text(errorMessage), Time: time.Now()})
+	return nil
+}
+
+func (a *AuditLog) LogAnalytics(invoiceID uint64, status, exchangerName string, duration float64, createdAt time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.Attempts = append(a.records.Attempts, Attempt{
+		InvoiceID:     invoiceID,
+		Status:        status,
+		ExchangerName: exchangerName,
+		DurationMs:    duration,
+		CreatedAt:     createdAt,
+		ProcessedAt:   time.Now(),
+	})
+	return nil
+}
+
+func (a *AuditLog) InvoiceHistoryInsert(invoiceId uint64, updatedBy string, status string, userId *uint64, details *string, at time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.History = append(a.records.History, HistoryEntry{
+		InvoiceID: invoiceId,
+		Status:    status,
+		UpdatedBy: updatedBy,
+		UserID:    copyUint64(userId),
+		Details:   copyString(details),
+		Time:      at,
+	})
+	return nil
+}
+
+func (a *AuditLog) LogBreakerState(exchangerID uint32, exchangerName, fromState, toState, reason string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.BreakerEvents = append(a.records.BreakerEvents, BreakerEvent{
+		ExchangerID:   exchangerID,
+		ExchangerName: exchangerName,
+		From:          fromState,
+		To:            toState,
+		Reason:        reason,
+		Time:          time.Now(),
+	})
+	return nil
+}
+
+func (a *AuditLog) LogRejectedTransition(invoiceID uint64, fromStatus, toStatus, updatedBy string, details *string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.RejectedTransitions = append(a.records.RejectedTransitions, RejectedTransition{
+		InvoiceID: invoiceID,
+		From:      fromStatus,
+		To:        toStatus,
+		UpdatedBy: updatedBy,
+		Details:   copyString(details),
+		Time:      time.Now(),
+	})
+	return nil
+}
+
+func (a *AuditLog) LogOrderCancel(invoiceID uint64, exchangerID uint32, externalID, reason, status, errorText string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.OrderCancels = append(a.records.OrderCancels, OrderCancel{
+		InvoiceID:   invoiceID,
+		ExchangerID: exchangerID,
+		ExternalID:  externalID,
+		Reason:      reason,
+		Status:      status,
+		Error:       redact.Default().Text(errorText),
+		Time:        time.Now(),
+	})
+	return nil
+}
+
+func (a *AuditLog) LogOrphanOrder(invoiceID uint64, exchangerID uint32, externalID, source, action, details string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records.OrphanOrders = append(a.records.OrphanOrders, OrphanOrder{
+		InvoiceID:   invoiceID,
+		ExchangerID: exchangerID,
+		ExternalID:  externalID,
+		Source:      source,
+		Action:      action,
+		Details:     redact.Default().Body("", details),
+		Time:        time.Now(),
+	})
+	return nil
+}
+
+func (a *AuditLog) SuccessfulApiRequests(since, until time.Time) ([]models.ApiRequestPair, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seen := make(map[attemptKey]bool)
+	var pairs []models.ApiRequestPair
+	for _, r := range a.records.ApiRequests {
+		if r.Time.Before(since) || !r.Time.Before(until) || (r.StatusCode != 200 && r.StatusCode != 201) {
+			continue
+		}
+		key := attemptKey{r.InvoiceID, r.ExchangerID}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		pairs = append(pairs, models.ApiRequestPair{InvoiceID: r.InvoiceID, ExchangerID: r.ExchangerID, Endpoint: r.Endpoint, Response: r.Response})
+	}
+	return pairs, nil
+}
+
+func (a *AuditLog) AnalyticsByExchanger(since time.Time) (map[string]models.ExchangerStats, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := make(map[string]models.ExchangerStats)
+	totals := make(map[string]float64)
+	for _, r := range a.records.Attempts {
+		if r.ProcessedAt.Before(since) {
+			continue
+		}
+		s := stats[r.ExchangerName]
+		s.Attempts++
+		if r.Status == "success" {
+			s.Successes++
+		}
+		totals[r.ExchangerName] += r.DurationMs
+		s.AvgLatencyMs = totals[r.ExchangerName] / float64(s.Attempts)
+		stats[r.ExchangerName] = s
+	}
+	return stats, nil
+}
+
+func (a *AuditLog) ApiCountsByExchanger(since time.Time) (map[uint32]models.ExchangerApiCounts, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	counts := make(map[uint32]models.ExchangerApiCounts)
+	for _, r := range a.records.ApiRequests {
+		if !r.Time.Before(since) {
+			c := counts[r.ExchangerID]
+			c.Requests++
+			counts[r.ExchangerID] = c
+		}
+	}
+	for _, r := range a.records.ApiErrors {
+		if !r.Time.Before(since) {
+			c := counts[r.ExchangerID]
+			c.Errors++
+			counts[r.ExchangerID] = c
+		}
+	}
+	return counts, nil
+}
+
+func (a *AuditLog) Close() {}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := *s
+	return &v
+}
+
+func copyUint64(n *uint64) *uint64 {
+	if n == nil {
+		return nil
+	}
+	v := *n
+	return &v
+}
@@ -0,0 +1,550 @@
+// Package memory - хранилища в памяти с поведением MySQL и ClickHouse для тестов без живых БД
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"payment-service-go/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dateTime - формат DATETIME, в котором сервис передаёт и хранит даты
+const dateTime = "2006-01-02 15:04:05"
+
+// Invoice - строка invoices. ExchangerID 0 и пустые строки соответствуют NULL
+type Invoice struct {
+	ID          uint64
+	ServiceID   uint64
+	ExchangerID uint32
+	ExternalID  string
+	Status      models.InvoiceStatus
+	Requisites  string
+	AmountIn    float64
+	Details     map[string]interface{}
+	ExpiryAt    string // DATETIME, сравнивается строкой, как в MySQL
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type serviceKey struct {
+	serviceID   uint64
+	exchangerID uint32
+}
+
+type attemptKey struct {
+	invoiceID   uint64
+	exchangerID uint32
+}
+
+type outboxRow struct {
+	id                    uint64
+	payload               []byte
+	attempts              int
+	nextAttemptAt         time.Time
+	lastError             string
+	clickhouseDeliveredAt time.Time
+	rabbitDeliveredAt     time.Time
+}
+
+// InvoiceStore - InvoiceStore в памяти: та же таблица переходов, outbox и журнал заявок, что у MySQL
+type InvoiceStore struct {
+	mu         sync.Mutex
+	invoices   map[uint64]*Invoice
+	exchangers map[uint32]models.Exchanger
+	services   map[serviceKey]models.Exchanger // ключи обменника для сервиса
+	attempts   map[attemptKey]models.OrderAttempt
+	marks      map[serviceKey]time.Time
+	outbox     []*outboxRow
+	outboxID   uint64
+}
+
+func NewInvoiceStore() *InvoiceStore {
+	return &InvoiceStore{
+		invoices:   make(map[uint64]*Invoice),
+		exchangers: make(map[uint32]models.Exchanger),
+		services:   make(map[serviceKey]models.Exchanger),
+		attempts:   make(map[attemptKey]models.OrderAttempt),
+		marks:      make(map[serviceKey]time.Time),
+	}
+}
+
+// AddExchanger подключает обменник к сервису: ID, Name и Endpoint попадают в exchangers,
+// APIKey и SecretKey - в service_exchangers
+func (s *InvoiceStore) AddExchanger(serviceID uint64, ex models.Exchanger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exchangers[ex.ID] = models.Exchanger{ID: ex.ID, Name: ex.Name, Endpoint: ex.Endpoint}
+	s.services[serviceKey{serviceID, ex.ID}] = models.Exchanger{APIKey: ex.APIKey, SecretKey: ex.SecretKey}
+}
+
+// AddInvoice добавляет или заменяет счет
+func (s *InvoiceStore) AddInvoice(invoice Invoice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if invoice.CreatedAt.IsZero() {
+		invoice.CreatedAt = time.Now().UTC()
+	}
+	s.invoices[invoice.ID] = &invoice
+}
+
+// Invoice возвращает копию счета
+func (s *InvoiceStore) Invoice(id uint64) (Invoice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invoice, ok := s.invoices[id]
+	if !ok {
+		return Invoice{}, false
+	}
+	return *invoice, true
+}
+
+// Events возвращает все события outbox в порядке записи, включая доставленные
+func (s *InvoiceStore) Events() []models.InvoiceEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]models.InvoiceEvent, 0, len(s.outbox))
+	for _, row := range s.outbox {
+		var event models.InvoiceEvent
+		json.Unmarshal(row.payload, &event)
+		events = append(events, event)
+	}
+	return events
+}
+
+func (s *InvoiceStore) UpdateInvoice(invoiceID uint64, exchangerId uint32, details models.DetailsRequisites, event models.InvoiceEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invoice, changed, err := s.transition(models.InvoiceCheckLite{ID: invoiceID}, models.StatusPending)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		// Счет уже в pending: повтор той же заявки ничего не меняет, чужая заявка отклоняется
+		if invoice.ExchangerID != exchangerId || invoice.ExternalID != details.ID {
+			return &models.TransitionError{InvoiceID: invoiceID, From: string(invoice.Status), To: models.StatusPending}
+		}
+		return nil
+	}
+	detailsJSON, err := json.Marshal(details.Details)
+	if err != nil {
+		return err
+	}
+	invoice.Status = models.StatusPending
+	invoice.UpdatedAt = time.Now()
+	invoice.ExternalID = details.ID
+	invoice.Requisites = details.Requisites
+	invoice.AmountIn = details.AmountIn
+	invoice.ExpiryAt = details.UntilAt
+	invoice.ExchangerID = exchangerId
+	invoice.Details = nil
+	json.Unmarshal(detailsJSON, &invoice.Details)
+	return s.insertOutbox(event)
+}
+
+func (s *InvoiceStore) UpdateGrooupInvoicesStatus(invoices []models.InvoiceCheckLite, status models.InvoiceStatus, events []models.InvoiceEvent) ([]*models.TransitionError, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Как транзакция MySQL: ошибка кроме запрещённого перехода не меняет ни одного счета
+	for _, invoice := range invoices {
+		if s.find(invoice) == nil {
+			return nil, fmt.Errorf("счет %d не найден", invoice.ID)
+		}
+	}
+
+	var rejected []*models.TransitionError
+	for i, invoice := range invoices {
+		row, changed, err := s.transition(invoice, status)
+		var transitionErr *models.TransitionError
+		if errors.As(err, &transitionErr) {
+			rejected = append(rejected, transitionErr)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			row.Status = status
+			row.UpdatedAt = time.Now()
+			if err := s.insertOutbox(events[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rejected, nil
+}
+
+func (s *InvoiceStore) UpdateInvoiceStatus(invoice models.InvoiceCheckLite, status models.InvoiceStatus, event models.InvoiceEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, changed, err := s.transition(invoice, status)
+	if err != nil || !changed {
+		return err
+	}
+	row.Status = status
+	row.UpdatedAt = time.Now()
+	return s.insertOutbox(event)
+}
+
+// transition проверяет переход по таблице, вызывается под s.mu. Статус меняет вызывающий
+func (s *InvoiceStore) transition(invoice models.InvoiceCheckLite, to models.InvoiceStatus) (*Invoice, bool, error) {
+	row := s.find(invoice)
+	if row == nil {
+		return nil, false, fmt.Errorf("счет %d не найден", invoice.ID)
+	}
+	if row.Status == to {
+		return row, false, nil
+	}
+	if !row.Status.CanTransition(to) {
+		return nil, false, &models.TransitionError{InvoiceID: invoice.ID, From: string(row.Status), To: to}
+	}
+	return row, true, nil
+}
+
+func (s *InvoiceStore) find(invoice models.InvoiceCheckLite) *Invoice {
+	row, ok := s.invoices[invoice.ID]
+	if !ok || (invoice.ExternalID != "" && row.ExternalID != invoice.ExternalID) {
+		return nil
+	}
+	return row
+}
+
+func (s *InvoiceStore) insertOutbox(event models.InvoiceEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.outboxID++
+	s.outbox = append(s.outbox, &outboxRow{id: s.outboxID, payload: payload, nextAttemptAt: time.Now()})
+	return nil
+}
+
+func (s *InvoiceStore) GetInvoiceByExternalIDAndServiceID(externalID string, serviceID uint64) (*models.InvoiceCheckLite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, invoice := range s.sorted() {
+		if invoice.ExternalID == externalID && invoice.ServiceID == serviceID {
+			return &models.InvoiceCheckLite{ID: invoice.ID, ExternalID: invoice.ExternalID}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *InvoiceStore) GetCallbackInvoice(exchangerName, externalID string) (*models.InvoiceCheck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, invoice := range s.sorted() {
+		ex, ok := s.exchangers[invoice.ExchangerID]
+		if !ok || ex.Name != exchangerName || invoice.ExternalID != externalID {
+			continue
+		}
+		keys, ok := s.services[serviceKey{invoice.ServiceID, ex.ID}]
+		if !ok {
+			continue
+		}
+		check := s.invoiceCheck(invoice, ex, keys)
+		return &check, nil
+	}
+	return nil, nil
+}
+
+func (s *InvoiceStore) GetCallbackCredentials(exchangerName string) (map[uint64]models.Exchanger, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	credentials := make(map[uint64]models.Exchanger)
+	for key, keys := range s.services {
+		ex, ok := s.exchangers[key.exchangerID]
+		if !ok || ex.Name != exchangerName {
+			continue
+		}
+		ex.APIKey = keys.APIKey
+		ex.SecretKey = keys.SecretKey
+		credentials[key.serviceID] = ex
+	}
+	return credentials, nil
+}
+
+func (s *InvoiceStore) GetExchangerCredentials(serviceID uint64, exchangerID uint32) (*models.Exchanger, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, ok := s.services[serviceKey{serviceID, exchangerID}]
+	ex, exists := s.exchangers[exchangerID]
+	if !ok || !exists {
+		return nil, nil
+	}
+	ex.APIKey = keys.APIKey
+	ex.SecretKey = keys.SecretKey
+	return &ex, nil
+}
+
+func (s *InvoiceStore) GetInvoicesByStatus(status string, date string) ([]models.InvoiceCheck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.selectInvoices(func(invoice *Invoice) bool {
+		return string(invoice.Status) == status && invoice.ExpiryAt != "" && invoice.ExpiryAt <= date
+	}), nil
+}
+
+func (s *InvoiceStore) GetActiveInvoices(date string) ([]models.InvoiceCheck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.selectInvoices(func(invoice *Invoice) bool {
+		active := invoice.Status == models.StatusPending || invoice.Status == models.StatusPendingConfirm
+		return active && invoice.ExpiryAt > date
+	}), nil
+}
+
+// selectInvoices - выборка счетов с обменником и ключами сервиса, по порядку ID обменника
+func (s *InvoiceStore) selectInvoices(match func(*Invoice) bool) []models.InvoiceCheck {
+	var result []models.InvoiceCheck
+	for _, invoice := range s.sorted() {
+		if invoice.ExternalID == "" || !match(invoice) {
+			continue
+		}
+		ex, ok := s.exchangers[invoice.ExchangerID]
+		if !ok {
+			continue
+		}
+		keys, ok := s.services[serviceKey{invoice.ServiceID, ex.ID}]
+		if !ok {
+			continue
+		}
+		result = append(result, s.invoiceCheck(invoice, ex, keys))
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Exchanger.ID < result[j].Exchanger.ID })
+	return result
+}
+
+func (s *InvoiceStore) invoiceCheck(invoice *Invoice, ex, keys models.Exchanger) models.InvoiceCheck {
+	ex.APIKey = keys.APIKey
+	ex.SecretKey = keys.SecretKey
+	ex.Amount = invoice.AmountIn
+	expiry, _ := time.Parse(dateTime, invoice.ExpiryAt)
+	return models.InvoiceCheck{
+		ID:         invoice.ID,
+		ExternalID: invoice.ExternalID,
+		ServiceID:  invoice.ServiceID,
+		CreatedAt:  invoice.CreatedAt,
+		ExpiryAt:   expiry,
+		Exchanger:  ex,
+	}
+}
+
+func (s *InvoiceStore) GetPendingInvoicesByExchanger(serviceID uint64, exchangerID uint32) ([]models.InvoiceCheckLite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []models.InvoiceCheckLite
+	for _, invoice := range s.sorted() {
+		active := invoice.Status == models.StatusPending || invoice.Status == models.StatusPendingConfirm
+		if active && invoice.ServiceID == serviceID && invoice.ExchangerID == exchangerID && invoice.ExternalID != "" {
+			expiry, _ := time.Parse(dateTime, invoice.ExpiryAt)
+			result = append(result, models.InvoiceCheckLite{ID: invoice.ID, ExternalID: invoice.ExternalID, ExpiryAt: expiry})
+		}
+	}
+	return result, nil
+}
+
+// sorted - счета по ID, вызывается под s.mu
+func (s *InvoiceStore) sorted() []*Invoice {
+	invoices := make([]*Invoice, 0, len(s.invoices))
+	for _, invoice := range s.invoices {
+		invoices = append(invoices, invoice)
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].ID < invoices[j].ID })
+	return invoices
+}
+
+func (s *InvoiceStore) GetSyncMark(serviceID uint64, exchangerID uint32) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.marks[serviceKey{serviceID, exchangerID}]
+	return until, ok, nil
+}
+
+func (s *InvoiceStore) SaveSyncMark(serviceID uint64, exchangerID uint32, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := serviceKey{serviceID, exchangerID}
+	// Точность DATETIME - секунда, назад отметка не двигается
+	until = until.UTC().Truncate(time.Second)
+	if current, ok := s.marks[key]; !ok || until.After(current) {
+		s.marks[key] = until
+	}
+	return nil
+}
+
+func (s *InvoiceStore) GetOrderAttempt(invoiceID uint64, exchangerID uint32) (*models.OrderAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt, ok := s.attempts[attemptKey{invoiceID, exchangerID}]
+	if !ok {
+		return nil, nil
+	}
+	return copyAttempt(attempt), nil
+}
+
+func (s *InvoiceStore) SaveOrderAttempt(attempt models.OrderAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := attemptKey{attempt.InvoiceID, attempt.ExchangerID}
+	now := time.Now()
+	attempt.CreatedAt = now
+	if existing, ok := s.attempts[key]; ok {
+		attempt.CreatedAt = existing.CreatedAt
+	}
+	attempt.UpdatedAt = now
+	s.attempts[key] = *copyAttempt(attempt)
+	return nil
+}
+
+func (s *InvoiceStore) SetOrderAttemptState(invoiceID uint64, exchangerID uint32, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := attemptKey{invoiceID, exchangerID}
+	if attempt, ok := s.attempts[key]; ok {
+		attempt.State = state
+		attempt.UpdatedAt = time.Now()
+		s.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (s *InvoiceStore) GetOrphanAttempts(since, before time.Time, limit int) ([]models.OrphanOrder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var orphans []models.OrphanOrder
+	for _, attempt := range s.attempts {
+		if attempt.State != models.OrderCreated && attempt.State != models.OrderCreating {
+			continue
+		}
+		if attempt.UpdatedAt.Before(since) || !attempt.UpdatedAt.Before(before) {
+			continue
+		}
+		invoice, ok := s.invoices[attempt.InvoiceID]
+		ex, exists := s.exchangers[attempt.ExchangerID]
+		if !ok || !exists {
+			continue
+		}
+		if invoice.ExchangerID == attempt.ExchangerID && invoice.ExternalID != "" && invoice.ExternalID == attempt.ExternalID {
+			continue
+		}
+		keys := s.services[serviceKey{invoice.ServiceID, attempt.ExchangerID}]
+		ex.APIKey = keys.APIKey
+		ex.SecretKey = keys.SecretKey
+		orphans = append(orphans, models.OrphanOrder{
+			Attempt:            *copyAttempt(attempt),
+			ServiceID:          invoice.ServiceID,
+			InvoiceStatus:      invoice.Status,
+			InvoiceExchangerID: invoice.ExchangerID,
+			Exchanger:          ex,
+		})
+	}
+
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Attempt.UpdatedAt.Before(orphans[j].Attempt.UpdatedAt) })
+	if limit >= 0 && len(orphans) > limit {
+		orphans = orphans[:limit]
+	}
+	return orphans, nil
+}
+
+func (s *InvoiceStore) FilterUnjournaled(pairs []models.ApiRequestPair) ([]models.ApiRequestPair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []models.ApiRequestPair
+	for _, pair := range pairs {
+		invoice, ok := s.invoices[pair.InvoiceID]
+		if !ok || invoice.ExchangerID == pair.ExchangerID {
+			continue
+		}
+		if _, journaled := s.attempts[attemptKey{pair.InvoiceID, pair.ExchangerID}]; journaled {
+			continue
+		}
+		result = append(result, pair)
+	}
+	return result, nil
+}
+
+func (s *InvoiceStore) FetchOutbox(limit int) ([]models.OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var entries []models.OutboxEntry
+	for _, row := range s.outbox {
+		if len(entries) >= limit {
+			break
+		}
+		clickhouseDone, rabbitDone := !row.clickhouseDeliveredAt.IsZero(), !row.rabbitDeliveredAt.IsZero()
+		if (clickhouseDone && rabbitDone) || row.nextAttemptAt.After(now) {
+			continue
+		}
+		entry := models.OutboxEntry{ID: row.id, Attempts: row.attempts, ClickHouseDone: clickhouseDone, RabbitDone: rabbitDone}
+		if err := json.Unmarshal(row.payload, &entry.Event); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *InvoiceStore) MarkOutboxDelivered(id uint64, destination string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range s.outbox {
+		if row.id != id {
+			continue
+		}
+		if destination == models.OutboxRabbit {
+			row.rabbitDeliveredAt = time.Now()
+		} else {
+			row.clickhouseDeliveredAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (s *InvoiceStore) MarkOutboxFailed(id uint64, retryAt time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range s.outbox {
+		if row.id == id {
+			row.attempts++
+			row.nextAttemptAt = retryAt
+			row.lastError = lastError
+		}
+	}
+	return nil
+}
+
+func (s *InvoiceStore) PurgeOutbox(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.outbox[:0]
+	for _, row := range s.outbox {
+		delivered := !row.clickhouseDeliveredAt.IsZero() && !row.rabbitDeliveredAt.IsZero()
+		if delivered && row.clickhouseDeliveredAt.Before(before) && row.rabbitDeliveredAt.Before(before) {
+			continue
+		}
+		kept = append(kept, row)
+	}
+	s.outbox = kept
+	return nil
+}
+
+func (s *InvoiceStore) Close() {}
+
+// copyAttempt копирует запись журнала вместе с реквизитами
+func copyAttempt(attempt models.OrderAttempt) *models.OrderAttempt {
+	if attempt.Details != nil {
+		details := *attempt.Details
+		attempt.Details = &details
+	}
+	return &attempt
+}
@@ -0,0 +1,99 @@
+// Package metrics - метрики Prometheus сервиса, отдаются на /metrics
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Исходы обработки сообщения очереди
+const (
+	OutcomeAck         = "ack"          // задача обработана
+	OutcomeRetry       = "retry"        // отложена в очередь ожидания
+	OutcomeNackRequeue = "nack_requeue" // возвращена в очередь
+	OutcomeDeadLetter  = "dead_letter"  // отправлена в dead_letter_queue
+)
+
+// Результаты перехода статуса счета
+const (
+	TransitionApplied  = "applied"
+	TransitionRejected = "rejected"
+)
+
+// Хранилища для StoreWriteFailures
+const (
+	StoreMySQL      = "mysql"
+	StoreClickHouse = "clickhouse"
+)
+
+var (
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "payment_queue_depth",
+		Help: "Сообщений в очереди RabbitMQ на момент последней проверки",
+	}, []string{"queue"})
+
+	ConsumeLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "payment_queue_consume_lag_seconds",
+		Help:    "Время от публикации задачи до начала обработки, только первая доставка",
+		Buckets: []float64{0.05, 0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+	})
+
+	Messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_queue_messages_total",
+		Help: "Сообщения очереди по исходу: ack, retry, nack_requeue, dead_letter",
+	}, []string{"outcome"})
+
+	RequisitesDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "payment_exchanger_requisites_duration_seconds",
+		Help:    "Длительность GetRequisites по обменнику",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16},
+	}, []string{"exchanger", "result"})
+
+	RequisitesErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_exchanger_requisites_errors_total",
+		Help: "Ошибки GetRequisites по обменнику и причине",
+	}, []string{"exchanger", "cause"})
+
+	ProcessInvoicesDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "payment_process_invoices_duration_seconds",
+		Help:    "Длительность прохода ProcessInvoices",
+		Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120},
+	})
+
+	InvoicesChecked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_invoices_checked_total",
+		Help: "Счета, отправленные на проверку статуса у обменника",
+	}, []string{"exchanger", "source", "result"})
+
+	StatusTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_invoice_status_transitions_total",
+		Help: "Переходы статуса счета: applied - принят хранилищем, rejected - запрещён",
+	}, []string{"status", "source", "result"})
+
+	StoreWriteFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_store_write_failures_total",
+		Help: "Неудачные записи в MySQL и ClickHouse по операции",
+	}, []string{"store", "op"})
+
+	ClickHouseSpilledRows = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "payment_clickhouse_spilled_rows_total",
+		Help: "Строки ClickHouse, сохранённые на диск до восстановления соединения",
+	})
+
+	ClickHouseDroppedRows = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "payment_clickhouse_dropped_rows_total",
+		Help: "Строки ClickHouse, удалённые с диска при превышении CLICKHOUSE_SPILL_MAX_MB",
+	})
+
+	ClickHouseQuarantinedRows = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "payment_clickhouse_quarantined_rows_total",
+		Help: "Строки, которые ClickHouse отверг как некорректные, перенесены в карантин",
+	})
+)
+
+// Handler отдаёт метрики в формате Prometheus
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
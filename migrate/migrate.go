@@ -0,0 +1,116 @@
+// Package migrate читает встроенные SQL-миграции и сверяет схему БД с ожидаемой
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration - один файл NNNN_name.sql, Statements выполняются по порядку
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// Load читает *.sql из корня fsys и сортирует по версии
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	seen := make(map[int]string)
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".sql")
+		prefix, title, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("миграция %s: имя должно быть вида 0001_name.sql", file)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("миграции %s и %s с одной версией %d", other, file, version)
+		}
+		seen[version] = file
+
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		statements := Split(string(body))
+		if len(statements) == 0 {
+			return nil, fmt.Errorf("миграция %s пустая", file)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: title, Statements: statements})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Split делит SQL на запросы по ";" в конце строки, строки-комментарии "--" отбрасываются
+func Split(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// Pending возвращает миграции, версий которых нет в applied
+func Pending(migrations []Migration, applied map[int]bool) []Migration {
+	var pending []Migration
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// Missing сравнивает ожидаемые колонки таблиц с фактическими и возвращает недостающие в виде table.column
+func Missing(expected map[string][]string, actual map[string]map[string]bool) []string {
+	var missing []string
+	for table, columns := range expected {
+		existing, ok := actual[table]
+		if !ok {
+			missing = append(missing, table)
+			continue
+		}
+		for _, column := range columns {
+			if !existing[column] {
+				missing = append(missing, table+"."+column)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// SchemaError - в БД нет таблиц или колонок, которые использует сервис
+type SchemaError struct {
+	Database string
+	Missing  []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("схема %s не совпадает с ожидаемой, нет: %s", e.Database, strings.Join(e.Missing, ", "))
+}
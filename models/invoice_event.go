@@ -0,0 +1,55 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// InvoiceEventVersion - версия схемы события, увеличивается при несовместимых изменениях
+const InvoiceEventVersion = 1
+
+const (
+	EventRequisitesAssigned = "invoice.requisites_assigned"
+)
+
+// InvoiceEvent - событие жизненного цикла счета для exchange invoice_events
+type InvoiceEvent struct {
+	ID          string                 `json:"id"`
+	Version     int                    `json:"version"`
+	Type        string                 `json:"type"`
+	InvoiceID   uint64                 `json:"invoice_id"`
+	Status      string                 `json:"status"`
+	ExchangerID uint32                 `json:"exchanger_id,omitempty"`
+	ExternalID  string                 `json:"external_id,omitempty"`
+	UpdatedBy   string                 `json:"updated_by,omitempty"`
+	OccurredAt  time.Time              `json:"occurred_at"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+// NewEventID - случайный идентификатор события для дедупликации у подписчиков
+func NewEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StatusEventType - тип события для смены статуса, например invoice.paid
+func StatusEventType(status string) string {
+	return "invoice." + status
+}
+
+// Приёмники событий invoice_outbox
+const (
+	OutboxClickHouse = "clickhouse"
+	OutboxRabbit     = "rabbit"
+)
+
+// OutboxEntry - событие из invoice_outbox и состояние его доставки
+type OutboxEntry struct {
+	ID             uint64
+	Event          InvoiceEvent
+	Attempts       int
+	ClickHouseDone bool
+	RabbitDone     bool
+}
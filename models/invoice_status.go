@@ -0,0 +1,77 @@
+package models
+
+import "fmt"
+
+// InvoiceStatus - статус счета в таблице invoices
+type InvoiceStatus string
+
+const (
+	// StatusSearch - условное обозначение начального статуса: счет создан продюсером,
+	// реквизиты ещё ищутся. Любой статус вне таблицы переходов считается начальным
+	StatusSearch InvoiceStatus = ""
+
+	StatusPending        InvoiceStatus = "pending"
+	StatusPendingConfirm InvoiceStatus = "pending_confirm"
+	StatusPaid           InvoiceStatus = "paid"
+	StatusError          InvoiceStatus = "error"
+	StatusCancelTime     InvoiceStatus = "cancel_time"
+	StatusCancelOperator InvoiceStatus = "cancel_operator"
+	StatusCancelInvalid  InvoiceStatus = "cancel_invalid"
+	StatusCancelSearch   InvoiceStatus = "cancel_search"
+)
+
+// transitions - допустимые переходы: из статуса -> в статусы.
+// paid и отмены оператором/валидацией/поиском конечные. cancel_time -> paid разрешён,
+// потому что обменник может подтвердить оплату позже нашего таймаута
+var transitions = map[InvoiceStatus][]InvoiceStatus{
+	StatusSearch:         {StatusPending, StatusCancelInvalid, StatusCancelSearch, StatusError},
+	StatusPending:        {StatusPendingConfirm, StatusPaid, StatusCancelTime, StatusCancelOperator, StatusError},
+	StatusPendingConfirm: {StatusPaid, StatusCancelOperator, StatusError},
+	StatusError:          {StatusPaid, StatusCancelOperator},
+	StatusCancelTime:     {StatusPaid},
+}
+
+var knownStatuses = map[InvoiceStatus]bool{
+	StatusPending:        true,
+	StatusPendingConfirm: true,
+	StatusPaid:           true,
+	StatusError:          true,
+	StatusCancelTime:     true,
+	StatusCancelOperator: true,
+	StatusCancelInvalid:  true,
+	StatusCancelSearch:   true,
+}
+
+// IsKnown - статус есть в таблице переходов
+func (s InvoiceStatus) IsKnown() bool {
+	return knownStatuses[s]
+}
+
+// Normalize сводит неизвестный статус к StatusSearch
+func (s InvoiceStatus) Normalize() InvoiceStatus {
+	if s.IsKnown() {
+		return s
+	}
+	return StatusSearch
+}
+
+// CanTransition сообщает, допустим ли переход from -> to
+func (s InvoiceStatus) CanTransition(to InvoiceStatus) bool {
+	for _, allowed := range transitions[s.Normalize()] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError - переход статуса отклонён таблицей переходов
+type TransitionError struct {
+	InvoiceID uint64
+	From      string
+	To        InvoiceStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("переход счета %d из статуса %q в %q запрещён", e.InvoiceID, e.From, e.To)
+}
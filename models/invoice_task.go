@@ -13,6 +13,7 @@ type InvoiceTask struct {
 
 type Invoice struct {
 	ID        uint64    `json:"id"`
+	ServiceID uint64    `json:"service_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -44,11 +45,27 @@ type InvoiceCheck struct {
 	ID         uint64    `json:"id"`
 	ExternalID string    `json:"external_id"`
 	ServiceID  uint64    `json:"service_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiryAt   time.Time `json:"expiry_at"`
 	Exchanger  Exchanger `json:"exchanger"`
 }
 type InvoiceCheckLite struct {
-	ID         uint64 `json:"id"`
-	ExternalID string `json:"external_id"`
+	ID         uint64    `json:"id"`
+	ExternalID string    `json:"external_id"`
+	ExpiryAt   time.Time `json:"expiry_at"`
+}
+
+// ExchangerStats - агрегаты по попыткам обменника из exchangers_analytics
+type ExchangerStats struct {
+	Attempts     uint64  `json:"attempts"`
+	Successes    uint64  `json:"successes"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// ExchangerApiCounts - количество успешных и ошибочных запросов к API обменника
+type ExchangerApiCounts struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
 }
 
 func (t *InvoiceTask) Validate() error {
@@ -64,23 +81,44 @@ func (t *InvoiceTask) Validate() error {
 		if err := ex.Validate(); err != nil {
 			return err
 		}
+		if !ex.HasCredentials() && t.Invoice.ServiceID == 0 {
+			return errors.New("service_id is required when exchanger credentials are not provided")
+		}
 	}
 
 	return nil
 }
 
+// WithoutCredentials возвращает копию задачи без ключей обменников, для логов и dead_letter_queue
+func (t InvoiceTask) WithoutCredentials() InvoiceTask {
+	exchangers := make([]Exchanger, len(t.Exchangers))
+	for i, ex := range t.Exchangers {
+		ex.APIKey = ""
+		ex.SecretKey = ""
+		exchangers[i] = ex
+	}
+	t.Exchangers = exchangers
+	return t
+}
+
+// HasCredentials - задача старого формата, ключи обменника переданы в сообщении.
+// В новом формате в сообщении только ID, остальное подставляется из service_exchangers
+func (e *Exchanger) HasCredentials() bool {
+	return e.APIKey != ""
+}
+
 func (e *Exchanger) Validate() error {
 	if e.ID <= 0 {
 		return errors.New("invalid exchanger ID")
 	}
-	if e.Name == "" {
-		return errors.New("exchanger name is empty")
-	}
 	if e.Amount <= 0 {
 		return errors.New("invalid exchanger amount")
 	}
-	if e.APIKey == "" {
-		return errors.New("exchanger API Key is empty")
+	if !e.HasCredentials() {
+		return nil
+	}
+	if e.Name == "" {
+		return errors.New("exchanger name is empty")
 	}
 	if _, err := url.ParseRequestURI(e.Endpoint); err != nil {
 		return errors.New("invalid exchanger endpoint")
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Состояния заявки в журнале invoice_exchanger_attempts
+const (
+	OrderCreating  = "creating"  // запрос на создание отправлен, ответ не получен
+	OrderCreated   = "created"   // заявка создана, реквизиты сохранены
+	OrderFailed    = "failed"    // обменник отказал, заявки нет
+	OrderCancelled = "cancelled" // заявка отменена у обменника
+	OrderOrphaned  = "orphaned"  // заявка без счета, нужен оператор
+)
+
+// OrderAttempt - запись журнала попыток создания заявки по паре (счет, обменник)
+type OrderAttempt struct {
+	InvoiceID   uint64             `json:"invoice_id"`
+	ExchangerID uint32             `json:"exchanger_id"`
+	State       string             `json:"state"`
+	ExternalID  string             `json:"external_id"`
+	Details     *DetailsRequisites `json:"details"`
+	Error       string             `json:"error"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// OrphanOrder - запись журнала, заявка которой не привязана к счету
+type OrphanOrder struct {
+	Attempt            OrderAttempt
+	ServiceID          uint64
+	InvoiceStatus      InvoiceStatus
+	InvoiceExchangerID uint32 // 0 - реквизиты счету ещё не выданы
+	Exchanger          Exchanger
+}
+
+// ApiRequestPair - успешные запросы к обменнику по счету из api_requests
+type ApiRequestPair struct {
+	InvoiceID   uint64
+	ExchangerID uint32
+	Endpoint    string
+	Response    string
+}
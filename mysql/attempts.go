@@ -0,0 +1,180 @@
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"payment-service-go/models"
+	"strings"
+	"time"
+)
+
+// GetOrderAttempt возвращает запись журнала заявок по паре (счет, обменник), nil - попыток не было
+func (l *MySQLDB) GetOrderAttempt(invoiceID uint64, exchangerID uint32) (*models.OrderAttempt, error) {
+	var attempt models.OrderAttempt
+	var externalID, details, errorText sql.NullString
+
+	err := l.db.QueryRow(
+		"SELECT invoice_id, exchanger_id, state, external_id, details, error, created_at, updated_at FROM invoice_exchanger_attempts WHERE invoice_id = ? AND exchanger_id = ?",
+		invoiceID, exchangerID,
+	).Scan(&attempt.InvoiceID, &attempt.ExchangerID, &attempt.State, &externalID, &details, &errorText, &attempt.CreatedAt, &attempt.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attempt.ExternalID = externalID.String
+	attempt.Error = errorText.String
+	if details.Valid && details.String != "" {
+		attempt.Details = &models.DetailsRequisites{}
+		if err := json.Unmarshal([]byte(details.String), attempt.Details); err != nil {
+			return nil, err
+		}
+	}
+	return &attempt, nil
+}
+
+// SaveOrderAttempt записывает состояние заявки в журнал, created_at сохраняется от первой попытки
+func (l *MySQLDB) SaveOrderAttempt(attempt models.OrderAttempt) error {
+	var details interface{}
+	if attempt.Details != nil {
+		raw, err := json.Marshal(attempt.Details)
+		if err != nil {
+			return err
+		}
+		details = string(raw)
+	}
+
+	// Время журнала пишется в UTC: так же его читает драйвер и так же хранятся сроки заявок
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	return l.exec(
+		"save_order_attempt",
+		"INSERT INTO invoice_exchanger_attempts (invoice_id, exchanger_id, state, external_id, details, error, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE state = VALUES(state), external_id = VALUES(external_id), details = VALUES(details), error = VALUES(error), updated_at = VALUES(updated_at)",
+		attempt.InvoiceID, attempt.ExchangerID, attempt.State, attempt.ExternalID, details, attempt.Error, now, now,
+	)
+}
+
+// SetOrderAttemptState меняет только состояние записи журнала
+func (l *MySQLDB) SetOrderAttemptState(invoiceID uint64, exchangerID uint32, state string) error {
+	return l.exec(
+		"set_order_attempt_state",
+		"UPDATE invoice_exchanger_attempts SET state = ?, updated_at = ? WHERE invoice_id = ? AND exchanger_id = ?",
+		state, time.Now().UTC().Format("2006-01-02 15:04:05"), invoiceID, exchangerID,
+	)
+}
+
+// GetOrphanAttempts возвращает заявки из журнала, обновлённые в [since, before), которые не привязаны к счету
+func (l *MySQLDB) GetOrphanAttempts(since, before time.Time, limit int) ([]models.OrphanOrder, error) {
+	rows, err := l.db.Query(
+		"SELECT a.invoice_id, a.exchanger_id, a.state, a.external_id, a.details, a.error, a.created_at, a.updated_at, i.service_id, i.status, IFNULL(i.exchanger_id, 0), e.name, e.endpoint, IFNULL(se.api_key, ''), IFNULL(se.secret_key, '') FROM invoice_exchanger_attempts a INNER JOIN invoices i ON i.id = a.invoice_id INNER JOIN exchangers e ON e.id = a.exchanger_id LEFT JOIN service_exchangers se ON se.service_id = i.service_id AND se.exchanger_id = a.exchanger_id WHERE a.state IN (?, ?) AND a.updated_at >= ? AND a.updated_at < ? AND NOT (i.exchanger_id <=> a.exchanger_id AND i.external_id <=> a.external_id) ORDER BY a.updated_at LIMIT ?",
+		models.OrderCreated, models.OrderCreating, since.UTC().Format("2006-01-02 15:04:05"), before.UTC().Format("2006-01-02 15:04:05"), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orphans []models.OrphanOrder
+	for rows.Next() {
+		var orphan models.OrphanOrder
+		var externalID, details, errorText, status sql.NullString
+		err := rows.Scan(
+			&orphan.Attempt.InvoiceID,
+			&orphan.Attempt.ExchangerID,
+			&orphan.Attempt.State,
+			&externalID,
+			&details,
+			&errorText,
+			&orphan.Attempt.CreatedAt,
+			&orphan.Attempt.UpdatedAt,
+			&orphan.ServiceID,
+			&status,
+			&orphan.InvoiceExchangerID,
+			&orphan.Exchanger.Name,
+			&orphan.Exchanger.Endpoint,
+			&orphan.Exchanger.APIKey,
+			&orphan.Exchanger.SecretKey,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		orphan.Exchanger.ID = orphan.Attempt.ExchangerID
+		orphan.Attempt.ExternalID = externalID.String
+		orphan.Attempt.Error = errorText.String
+		orphan.InvoiceStatus = models.InvoiceStatus(status.String)
+		if details.Valid && details.String != "" {
+			orphan.Attempt.Details = &models.DetailsRequisites{}
+			if err := json.Unmarshal([]byte(details.String), orphan.Attempt.Details); err != nil {
+				return nil, err
+			}
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, rows.Err()
+}
+
+// FilterUnjournaled оставляет пары (счет, обменник), которых нет в журнале и обменник которых не выдал реквизиты счету
+func (l *MySQLDB) FilterUnjournaled(pairs []models.ApiRequestPair) ([]models.ApiRequestPair, error) {
+	var result []models.ApiRequestPair
+
+	for start := 0; start < len(pairs); start += 500 {
+		end := start + 500
+		if end > len(pairs) {
+			end = len(pairs)
+		}
+		chunk := pairs[start:end]
+
+		placeholders := make([]string, len(chunk))
+		args := make([]interface{}, len(chunk))
+		for i, pair := range chunk {
+			placeholders[i] = "?"
+			args[i] = pair.InvoiceID
+		}
+		in := strings.Join(placeholders, ", ")
+
+		attached := make(map[uint64]uint32)
+		rows, err := l.db.Query("SELECT id, IFNULL(exchanger_id, 0) FROM invoices WHERE id IN ("+in+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id uint64
+			var exchangerID uint32
+			if err := rows.Scan(&id, &exchangerID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			attached[id] = exchangerID
+		}
+		rows.Close()
+
+		journaled := make(map[[2]uint64]bool)
+		rows, err = l.db.Query("SELECT invoice_id, exchanger_id FROM invoice_exchanger_attempts WHERE invoice_id IN ("+in+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var invoiceID uint64
+			var exchangerID uint32
+			if err := rows.Scan(&invoiceID, &exchangerID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			journaled[[2]uint64{invoiceID, uint64(exchangerID)}] = true
+		}
+		rows.Close()
+
+		for _, pair := range chunk {
+			exchangerID, exists := attached[pair.InvoiceID]
+			if !exists || exchangerID == pair.ExchangerID || journaled[[2]uint64{pair.InvoiceID, uint64(pair.ExchangerID)}] {
+				continue
+			}
+			result = append(result, pair)
+		}
+	}
+
+	return result, nil
+}
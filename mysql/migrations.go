@@ -0,0 +1,136 @@
+package mysql
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	driver "github.com/go-sql-driver/mysql"
+	"io/fs"
+	"log"
+	"payment-service-go/migrate"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// expectedColumns - таблицы и колонки, которые используют запросы пакета
+var expectedColumns = map[string][]string{
+	"invoices":                   {"id", "service_id", "exchanger_id", "external_id", "status", "requisites", "amount_in", "details", "expiry_at", "created_at", "updated_at"},
+	"exchangers":                 {"id", "name", "endpoint"},
+	"service_exchangers":         {"service_id", "exchanger_id", "api_key", "secret_key"},
+	"invoice_outbox":             {"id", "event_id", "invoice_id", "event_type", "payload", "attempts", "next_attempt_at", "last_error", "clickhouse_delivered_at", "rabbit_delivered_at", "created_at"},
+	"exchanger_sync_marks":       {"service_id", "exchanger_id", "synced_until", "updated_at"},
+	"invoice_exchanger_attempts": {"invoice_id", "exchanger_id", "state", "external_id", "details", "error", "created_at", "updated_at"},
+}
+
+// Ошибки "уже существует": миграции, повторяющие схему основного приложения, не падают на готовой БД
+var idempotentErrors = map[uint16]bool{
+	1050: true, // ER_TABLE_EXISTS_ERROR
+	1060: true, // ER_DUP_FIELDNAME
+	1061: true, // ER_DUP_KEYNAME
+}
+
+// Migrate применяет встроенные миграции, которых нет в schema_migrations.
+// Несколько экземпляров сервиса не накатывают миграции одновременно благодаря GET_LOCK
+func (l *MySQLDB) Migrate() error {
+	dir, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+	migrations, err := migrate.Load(dir)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked int
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK('payment_service_migrations', 60)").Scan(&locked); err != nil {
+		return err
+	}
+	if locked != 1 {
+		return errors.New("не удалось получить блокировку миграций MySQL")
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK('payment_service_migrations')")
+
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INT UNSIGNED NOT NULL, name VARCHAR(255) NOT NULL, applied_at DATETIME NOT NULL, PRIMARY KEY (version)) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4")
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int]bool)
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range migrate.Pending(migrations, applied) {
+		// DDL в MySQL фиксируется сразу, поэтому миграция не оборачивается в транзакцию
+		for _, statement := range m.Statements {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				var mysqlErr *driver.MySQLError
+				if errors.As(err, &mysqlErr) && idempotentErrors[mysqlErr.Number] {
+					log.Printf("MySQL миграция %04d_%s: %v, пропуск", m.Version, m.Name, err)
+					continue
+				}
+				return fmt.Errorf("миграция MySQL %04d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		_, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+		log.Printf("MySQL: применена миграция %04d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// CheckSchema проверяет, что в БД есть все таблицы и колонки из expectedColumns.
+// Возвращает *migrate.SchemaError со списком недостающих
+func (l *MySQLDB) CheckSchema() error {
+	rows, err := l.db.Query("SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = DATABASE()")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	actual := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return err
+		}
+		if actual[table] == nil {
+			actual[table] = make(map[string]bool)
+		}
+		actual[table][column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if missing := migrate.Missing(expectedColumns, actual); len(missing) > 0 {
+		return &migrate.SchemaError{Database: "MySQL", Missing: missing}
+	}
+	return nil
+}
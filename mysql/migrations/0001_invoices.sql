@@ -0,0 +1,37 @@
+-- Таблицы основного приложения, которые сервис читает и обновляет. Нужны только для пустой БД разработки:
+-- на существующей CREATE TABLE IF NOT EXISTS ничего не меняет, изменения этих таблиц выпускает
+-- основное приложение (mysql/owner), расхождения покажет проверка схемы при старте
+
+CREATE TABLE IF NOT EXISTS exchangers (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(64) NOT NULL,
+    endpoint VARCHAR(255) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY exchangers_name_unique (name)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS service_exchangers (
+    service_id BIGINT UNSIGNED NOT NULL,
+    exchanger_id INT UNSIGNED NOT NULL,
+    api_key VARCHAR(255) NOT NULL,
+    secret_key VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (service_id, exchanger_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    service_id BIGINT UNSIGNED NOT NULL,
+    exchanger_id INT UNSIGNED NULL,
+    external_id VARCHAR(255) NULL,
+    status VARCHAR(32) NULL,
+    requisites VARCHAR(255) NULL,
+    amount_in DECIMAL(18, 2) NULL,
+    details JSON NULL,
+    expiry_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NULL,
+    PRIMARY KEY (id),
+    KEY invoices_status_expiry (status, expiry_at),
+    KEY invoices_exchanger_external (exchanger_id, external_id),
+    KEY invoices_service_external (service_id, external_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
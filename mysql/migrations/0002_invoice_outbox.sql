@@ -0,0 +1,18 @@
+-- События смены статуса счета для доставки в invoice_history и RabbitMQ
+
+CREATE TABLE IF NOT EXISTS invoice_outbox (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    event_id VARCHAR(64) NOT NULL,
+    invoice_id BIGINT UNSIGNED NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    attempts INT UNSIGNED NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_error TEXT NULL,
+    clickhouse_delivered_at DATETIME NULL,
+    rabbit_delivered_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY invoice_outbox_event_id_unique (event_id),
+    KEY invoice_outbox_next_attempt (next_attempt_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,9 @@
+-- До какого момента заявки обменника сверены со счетами сервиса
+
+CREATE TABLE IF NOT EXISTS exchanger_sync_marks (
+    service_id BIGINT UNSIGNED NOT NULL,
+    exchanger_id INT UNSIGNED NOT NULL,
+    synced_until DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (service_id, exchanger_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
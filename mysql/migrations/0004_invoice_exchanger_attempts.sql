@@ -0,0 +1,14 @@
+-- Журнал заявок у обменников по счету, защищает от повторного создания заявки
+
+CREATE TABLE IF NOT EXISTS invoice_exchanger_attempts (
+    invoice_id BIGINT UNSIGNED NOT NULL,
+    exchanger_id INT UNSIGNED NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    external_id VARCHAR(255) NULL,
+    details JSON NULL,
+    error TEXT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (invoice_id, exchanger_id),
+    KEY invoice_exchanger_attempts_state_updated (state, updated_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
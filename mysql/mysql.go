@@ -4,13 +4,13 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"payment-service-go/metrics"
 	"payment-service-go/models"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -43,56 +43,183 @@ func NewMySQLDB() (*MySQLDB, error) {
 	return &MySQLDB{db: db}, nil
 }
 
-func (l *MySQLDB) UpdateInvoice(invoiceID uint64, exchangerId uint32, details models.DetailsRequisites) error {
+// UpdateInvoice сохраняет реквизиты и переводит счет в pending, в той же транзакции пишет событие в outbox.
+// Если счет уже отменён или получил реквизиты другой заявки, возвращает *models.TransitionError,
+// чтобы вызывающий отменил свою заявку. Повтор с той же заявкой ничего не меняет
+func (l *MySQLDB) UpdateInvoice(invoiceID uint64, exchangerId uint32, details models.DetailsRequisites, event models.InvoiceEvent) error {
 	detailsJSON, err := json.Marshal(details.Details)
 	if err != nil {
 		return err
 	}
 
-	_, err = l.db.Exec(
-		"UPDATE invoices SET external_id = ?, requisites = ?, amount_in = ?, expiry_at = ?, status = ?, exchanger_id = ?, details = ?, updated_at = ? WHERE id = ?",
-		details.ID, details.Requisites, details.AmountIn, details.UntilAt, "pending", exchangerId, string(detailsJSON), time.Now().Format("2006-01-02 15:04:05"), invoiceID,
-	)
+	err = l.inTx("update_invoice", func(tx *sql.Tx) error {
+		if err := assignedToOther(tx, invoiceID, exchangerId, details.ID); err != nil {
+			return err
+		}
+		changed, err := transition(tx, models.InvoiceCheckLite{ID: invoiceID}, models.StatusPending,
+			", external_id = ?, requisites = ?, amount_in = ?, expiry_at = ?, exchanger_id = ?, details = ?",
+			details.ID, details.Requisites, details.AmountIn, details.UntilAt, exchangerId, string(detailsJSON),
+		)
+		if err != nil || !changed {
+			return err
+		}
+		return insertOutbox(tx, event)
+	})
 	if err != nil {
 		log.Printf("Ошибка MySQL для invoice %d: %v", invoiceID, err)
 		return err
 	}
-	log.Printf("MySQL: Invoice %d обновлён, status=%s, exchanger=%d", invoiceID, "pending", exchangerId)
+	log.Printf("MySQL: Invoice %d обновлён, status=%s, exchanger=%d", invoiceID, models.StatusPending, exchangerId)
 	return nil
 }
 
-func (l *MySQLDB) UpdateGrooupInvoicesStatus(invoicesIDs []uint64, status string) error {
-	strArr := make([]string, len(invoicesIDs))
-	for i, num := range invoicesIDs {
-		strArr[i] = strconv.FormatUint(num, 10)
+// UpdateGrooupInvoicesStatus меняет статус группы счетов, events[i] - событие для invoices[i].
+// Счета с недопустимым переходом пропускаются и возвращаются в rejected
+func (l *MySQLDB) UpdateGrooupInvoicesStatus(invoices []models.InvoiceCheckLite, status models.InvoiceStatus, events []models.InvoiceEvent) ([]*models.TransitionError, error) {
+	var rejected []*models.TransitionError
+
+	err := l.inTx("update_invoices_status", func(tx *sql.Tx) error {
+		rejected = nil
+		for i, invoice := range invoices {
+			changed, err := transition(tx, invoice, status, "")
+			var transitionErr *models.TransitionError
+			if errors.As(err, &transitionErr) {
+				rejected = append(rejected, transitionErr)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if changed {
+				if err := insertOutbox(tx, events[i]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("Ошибка MySQL при обработке массово инвойсов %v", err)
+		return nil, err
 	}
 
-	invoicesIDsForQuery := strings.Join(strArr, ",")
+	return rejected, nil
+}
 
-	_, err := l.db.Exec(
-		"UPDATE invoices SET status = ? WHERE id IN (?)", status, invoicesIDsForQuery)
+// UpdateInvoiceStatus меняет статус счета, если переход допустим, и в той же транзакции пишет событие в outbox.
+// Без ExternalID счет ищется только по ID. Недопустимый переход возвращает *models.TransitionError
+func (l *MySQLDB) UpdateInvoiceStatus(invoice models.InvoiceCheckLite, status models.InvoiceStatus, event models.InvoiceEvent) error {
+	changed := false
+	err := l.inTx("update_invoice_status", func(tx *sql.Tx) error {
+		var err error
+		changed, err = transition(tx, invoice, status, "")
+		if err != nil || !changed {
+			return err
+		}
+		return insertOutbox(tx, event)
+	})
 
 	if err != nil {
-		log.Printf("Ошибка MySQL при обработке массово инвойсов %v", err)
+		log.Printf("Ошибка MySQL для invoice %d: %v", invoice.ID, err)
 		return err
 	}
 
+	if changed {
+		log.Printf("MySQL: Invoice %d обновлён, status=%s", invoice.ID, status)
+	}
+	return nil
+}
+
+// assignedToOther блокирует строку счета и возвращает *models.TransitionError, если счет уже в pending
+// с заявкой другого обменника или с другим external_id
+func assignedToOther(tx *sql.Tx, invoiceID uint64, exchangerID uint32, externalID string) error {
+	var status, currentExternalID sql.NullString
+	var currentExchangerID sql.NullInt64
+	err := tx.QueryRow("SELECT status, exchanger_id, external_id FROM invoices WHERE id = ? FOR UPDATE", invoiceID).
+		Scan(&status, &currentExchangerID, &currentExternalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("счет %d не найден", invoiceID)
+	}
+	if err != nil {
+		return err
+	}
+	if models.InvoiceStatus(status.String) == models.StatusPending &&
+		(currentExchangerID.Int64 != int64(exchangerID) || currentExternalID.String != externalID) {
+		return &models.TransitionError{InvoiceID: invoiceID, From: status.String, To: models.StatusPending}
+	}
 	return nil
 }
 
-func (l *MySQLDB) UpdateInvoiceStatus(invoice models.InvoiceCheckLite, status string) error {
-	_, err := l.db.Exec(
-		"UPDATE invoices SET status = ?, updated_at  = ? WHERE id = ? AND external_id = ?",
-		status, time.Now().Format("2006-01-02 15:04:05"), invoice.ID, invoice.ExternalID,
-	)
+// transition блокирует строку счета и меняет статус, если переход допустим.
+// set и setArgs - дополнительные колонки для UPDATE. Возвращает false, если счет уже в статусе to
+func transition(tx *sql.Tx, invoice models.InvoiceCheckLite, to models.InvoiceStatus, set string, setArgs ...interface{}) (bool, error) {
+	query := "SELECT status FROM invoices WHERE id = ?"
+	args := []interface{}{invoice.ID}
+	if invoice.ExternalID != "" {
+		query += " AND external_id = ?"
+		args = append(args, invoice.ExternalID)
+	}
 
+	var current sql.NullString
+	err := tx.QueryRow(query+" FOR UPDATE", args...).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("счет %d не найден", invoice.ID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	from := models.InvoiceStatus(current.String)
+	if from == to {
+		return false, nil
+	}
+	if !from.CanTransition(to) {
+		return false, &models.TransitionError{InvoiceID: invoice.ID, From: current.String, To: to}
+	}
+
+	updateArgs := []interface{}{string(to), time.Now().Format("2006-01-02 15:04:05")}
+	updateArgs = append(updateArgs, setArgs...)
+	updateArgs = append(updateArgs, invoice.ID, current)
+	_, err = tx.Exec("UPDATE invoices SET status = ?, updated_at = ?"+set+" WHERE id = ? AND status <=> ?", updateArgs...)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// inTx выполняет fn в транзакции, при ошибке транзакция откатывается.
+// op - имя операции в метрике неудачных записей, запрещённый переход статуса сбоем записи не считается
+func (l *MySQLDB) inTx(op string, fn func(tx *sql.Tx) error) error {
+	err := l.runTx(fn)
+	var transitionErr *models.TransitionError
+	if err != nil && !errors.As(err, &transitionErr) {
+		metrics.StoreWriteFailures.WithLabelValues(metrics.StoreMySQL, op).Inc()
+	}
+	return err
+}
+
+func (l *MySQLDB) runTx(fn func(tx *sql.Tx) error) error {
+	tx, err := l.db.Begin()
 	if err != nil {
-		log.Printf("Ошибка MySQL для invoice %d: %v", invoice.ID, err)
 		return err
 	}
+	if err := fn(tx); err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			log.Printf("Не удалось выполнить rollback MySQL: %v", errRollback)
+		}
+		return err
+	}
+	return tx.Commit()
+}
 
-	log.Printf("MySQL: Invoice %d обновлён, status=%s", invoice.ID, status)
-	return nil
+// exec выполняет запрос записи, ошибка учитывается в метрике неудачных записей под именем op
+func (l *MySQLDB) exec(op, query string, args ...interface{}) error {
+	_, err := l.db.Exec(query, args...)
+	if err != nil {
+		metrics.StoreWriteFailures.WithLabelValues(metrics.StoreMySQL, op).Inc()
+	}
+	return err
 }
 
 func (l *MySQLDB) GetInvoiceByExternalIDAndServiceID(externalID string, serviceID uint64) (*models.InvoiceCheckLite, error) {
@@ -112,6 +239,79 @@ func (l *MySQLDB) GetInvoiceByExternalIDAndServiceID(externalID string, serviceI
 
 }
 
+// GetCallbackInvoice ищет счет по внешнему ID заявки обменника вместе с ключами сервиса для проверки подписи
+func (l *MySQLDB) GetCallbackInvoice(exchangerName, externalID string) (*models.InvoiceCheck, error) {
+	var invoice models.InvoiceCheck
+
+	row := l.db.QueryRow(
+		"SELECT i.id, i.external_id, i.service_id, e.id, e.name, e.endpoint, se.api_key, se.secret_key FROM invoices i INNER JOIN exchangers e ON e.id = i.exchanger_id INNER JOIN service_exchangers se ON se.service_id = i.service_id AND se.exchanger_id = e.id WHERE e.name = ? AND i.external_id = ? LIMIT 1",
+		exchangerName, externalID,
+	)
+
+	err := row.Scan(
+		&invoice.ID,
+		&invoice.ExternalID,
+		&invoice.ServiceID,
+		&invoice.Exchanger.ID,
+		&invoice.Exchanger.Name,
+		&invoice.Exchanger.Endpoint,
+		&invoice.Exchanger.APIKey,
+		&invoice.Exchanger.SecretKey,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+// GetCallbackCredentials возвращает ключи обменника по ID сервиса, чтобы проверить подпись уведомления
+// до поиска счета
+func (l *MySQLDB) GetCallbackCredentials(exchangerName string) (map[uint64]models.Exchanger, error) {
+	rows, err := l.db.Query(
+		"SELECT se.service_id, e.id, e.name, e.endpoint, se.api_key, se.secret_key FROM service_exchangers se INNER JOIN exchangers e ON e.id = se.exchanger_id WHERE e.name = ?",
+		exchangerName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := make(map[uint64]models.Exchanger)
+	for rows.Next() {
+		var serviceID uint64
+		var ex models.Exchanger
+		if err := rows.Scan(&serviceID, &ex.ID, &ex.Name, &ex.Endpoint, &ex.APIKey, &ex.SecretKey); err != nil {
+			return nil, err
+		}
+		credentials[serviceID] = ex
+	}
+	return credentials, rows.Err()
+}
+
+// GetExchangerCredentials возвращает имя, адрес и ключи обменника для сервиса, nil - связка не найдена
+func (l *MySQLDB) GetExchangerCredentials(serviceID uint64, exchangerID uint32) (*models.Exchanger, error) {
+	var ex models.Exchanger
+
+	row := l.db.QueryRow(
+		"SELECT e.id, e.name, e.endpoint, se.api_key, se.secret_key FROM service_exchangers se INNER JOIN exchangers e ON e.id = se.exchanger_id WHERE se.service_id = ? AND se.exchanger_id = ? LIMIT 1",
+		serviceID, exchangerID,
+	)
+
+	err := row.Scan(&ex.ID, &ex.Name, &ex.Endpoint, &ex.APIKey, &ex.SecretKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &ex, nil
+}
+
 func (l *MySQLDB) CustomQuery(query string, args ...interface{}) error {
 	_, err := l.db.Exec(query, args...)
 
@@ -120,7 +320,7 @@ func (l *MySQLDB) CustomQuery(query string, args ...interface{}) error {
 
 func (l *MySQLDB) GetInvoicesByStatus(status string, date string) ([]models.InvoiceCheck, error) {
 	rows, err := l.db.Query(
-		"SELECT i.id, i.external_id, i.amount_in, i.service_id, e.name, e.endpoint, se.api_key FROM invoices i INNER JOIN service_exchangers se ON se.service_id = i.service_id INNER JOIN exchangers e ON e.id = i.exchanger_id AND se.exchanger_id = e.id WHERE i.status = ? AND i.expiry_at <= ? AND i.external_id IS NOT NULL AND i.expiry_at IS NOT NULL ORDER BY e.id",
+		"SELECT i.id, i.external_id, i.amount_in, i.service_id, i.expiry_at, e.id, e.name, e.endpoint, se.api_key, se.secret_key FROM invoices i INNER JOIN service_exchangers se ON se.service_id = i.service_id INNER JOIN exchangers e ON e.id = i.exchanger_id AND se.exchanger_id = e.id WHERE i.status = ? AND i.expiry_at <= ? AND i.external_id IS NOT NULL AND i.expiry_at IS NOT NULL ORDER BY e.id",
 		status, date,
 	)
 	if err != nil {
@@ -137,9 +337,51 @@ func (l *MySQLDB) GetInvoicesByStatus(status string, date string) ([]models.Invo
 			&invoice.ExternalID,
 			&invoice.Exchanger.Amount,
 			&invoice.ServiceID,
+			&invoice.ExpiryAt,
+			&invoice.Exchanger.ID,
+			&invoice.Exchanger.Name,
+			&invoice.Exchanger.Endpoint,
+			&invoice.Exchanger.APIKey,
+			&invoice.Exchanger.SecretKey,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// GetActiveInvoices возвращает счета с выданными реквизитами, срок которых ещё не истёк
+func (l *MySQLDB) GetActiveInvoices(date string) ([]models.InvoiceCheck, error) {
+	rows, err := l.db.Query(
+		"SELECT i.id, i.external_id, i.service_id, i.created_at, i.expiry_at, e.id, e.name, e.endpoint, se.api_key, se.secret_key FROM invoices i INNER JOIN service_exchangers se ON se.service_id = i.service_id INNER JOIN exchangers e ON e.id = i.exchanger_id AND se.exchanger_id = e.id WHERE i.status IN (?, ?) AND i.expiry_at > ? AND i.external_id IS NOT NULL ORDER BY e.id",
+		string(models.StatusPending), string(models.StatusPendingConfirm), date,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []models.InvoiceCheck
+
+	for rows.Next() {
+		var invoice models.InvoiceCheck
+		err := rows.Scan(
+			&invoice.ID,
+			&invoice.ExternalID,
+			&invoice.ServiceID,
+			&invoice.CreatedAt,
+			&invoice.ExpiryAt,
+			&invoice.Exchanger.ID,
 			&invoice.Exchanger.Name,
 			&invoice.Exchanger.Endpoint,
 			&invoice.Exchanger.APIKey,
+			&invoice.Exchanger.SecretKey,
 		)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,84 @@
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"payment-service-go/models"
+	"time"
+)
+
+// insertOutbox пишет события в invoice_outbox внутри транзакции изменения счета
+func insertOutbox(tx *sql.Tx, events ...models.InvoiceEvent) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO invoice_outbox (event_id, invoice_id, event_type, payload, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, 0, ?, ?)",
+			event.ID, event.InvoiceID, event.Type, string(payload), now, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchOutbox возвращает события, не доставленные хотя бы в один приёмник, в порядке записи
+func (l *MySQLDB) FetchOutbox(limit int) ([]models.OutboxEntry, error) {
+	rows, err := l.db.Query(
+		"SELECT id, payload, attempts, clickhouse_delivered_at IS NOT NULL, rabbit_delivered_at IS NOT NULL FROM invoice_outbox WHERE (clickhouse_delivered_at IS NULL OR rabbit_delivered_at IS NULL) AND next_attempt_at <= ? ORDER BY id LIMIT ?",
+		time.Now().Format("2006-01-02 15:04:05"), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.OutboxEntry
+	for rows.Next() {
+		var entry models.OutboxEntry
+		var payload string
+		if err := rows.Scan(&entry.ID, &payload, &entry.Attempts, &entry.ClickHouseDone, &entry.RabbitDone); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(payload), &entry.Event); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// MarkOutboxDelivered отмечает доставку события в приёмник
+func (l *MySQLDB) MarkOutboxDelivered(id uint64, destination string) error {
+	column := "clickhouse_delivered_at"
+	if destination == models.OutboxRabbit {
+		column = "rabbit_delivered_at"
+	}
+	return l.exec(
+		"mark_outbox_delivered",
+		"UPDATE invoice_outbox SET "+column+" = ? WHERE id = ?",
+		time.Now().Format("2006-01-02 15:04:05"), id,
+	)
+}
+
+// MarkOutboxFailed откладывает следующую попытку доставки
+func (l *MySQLDB) MarkOutboxFailed(id uint64, retryAt time.Time, lastError string) error {
+	return l.exec(
+		"mark_outbox_failed",
+		"UPDATE invoice_outbox SET attempts = attempts + 1, next_attempt_at = ?, last_error = ? WHERE id = ?",
+		retryAt.Format("2006-01-02 15:04:05"), lastError, id,
+	)
+}
+
+// PurgeOutbox удаляет события, доставленные во все приёмники раньше before
+func (l *MySQLDB) PurgeOutbox(before time.Time) error {
+	return l.exec(
+		"purge_outbox",
+		"DELETE FROM invoice_outbox WHERE clickhouse_delivered_at < ? AND rabbit_delivered_at < ?",
+		before.Format("2006-01-02 15:04:05"), before.Format("2006-01-02 15:04:05"),
+	)
+}
@@ -0,0 +1,5 @@
+-- Миграция для основного приложения, которому принадлежит service_exchangers.
+-- Сервис её не накатывает: без колонки запуск останавливает проверка схемы (SCHEMA_CHECK=strict)
+-- Секрет для подписи запросов к обменникам и проверки callback
+
+ALTER TABLE service_exchangers ADD COLUMN secret_key VARCHAR(255) NOT NULL DEFAULT '' AFTER api_key;
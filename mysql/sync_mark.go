@@ -0,0 +1,57 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"payment-service-go/models"
+	"time"
+)
+
+// GetSyncMark возвращает момент, до которого статусы обменника для сервиса уже синхронизированы
+func (l *MySQLDB) GetSyncMark(serviceID uint64, exchangerID uint32) (time.Time, bool, error) {
+	var until time.Time
+	err := l.db.QueryRow(
+		"SELECT synced_until FROM exchanger_sync_marks WHERE service_id = ? AND exchanger_id = ?",
+		serviceID, exchangerID,
+	).Scan(&until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return until, true, nil
+}
+
+// SaveSyncMark сдвигает отметку синхронизации вперёд, назад отметка не двигается
+func (l *MySQLDB) SaveSyncMark(serviceID uint64, exchangerID uint32, until time.Time) error {
+	return l.exec(
+		"save_sync_mark",
+		"INSERT INTO exchanger_sync_marks (service_id, exchanger_id, synced_until, updated_at) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE synced_until = GREATEST(synced_until, VALUES(synced_until)), updated_at = VALUES(updated_at)",
+		serviceID, exchangerID, until.UTC().Format("2006-01-02 15:04:05"), time.Now().Format("2006-01-02 15:04:05"),
+	)
+}
+
+// GetPendingInvoicesByExchanger возвращает счета сервиса у обменника, ожидающие оплаты
+func (l *MySQLDB) GetPendingInvoicesByExchanger(serviceID uint64, exchangerID uint32) ([]models.InvoiceCheckLite, error) {
+	rows, err := l.db.Query(
+		"SELECT id, external_id, expiry_at FROM invoices WHERE service_id = ? AND exchanger_id = ? AND status IN (?, ?) AND external_id IS NOT NULL",
+		serviceID, exchangerID, string(models.StatusPending), string(models.StatusPendingConfirm),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []models.InvoiceCheckLite
+	for rows.Next() {
+		var invoice models.InvoiceCheckLite
+		var expiryAt sql.NullTime
+		if err := rows.Scan(&invoice.ID, &invoice.ExternalID, &expiryAt); err != nil {
+			return nil, err
+		}
+		invoice.ExpiryAt = expiryAt.Time
+		invoices = append(invoices, invoice)
+	}
+	return invoices, rows.Err()
+}
@@ -0,0 +1,95 @@
+package rabbit
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"log"
+	"sync"
+	"time"
+)
+
+// confirmTimeout - сколько ждать подтверждения публикации от брокера
+const confirmTimeout = 5 * time.Second
+
+// confirmChannel - отдельный канал в режиме publisher confirms. Публикации идут по одной:
+// следующая начинается только после подтверждения предыдущей
+type confirmChannel struct {
+	r *RabbitMQ
+
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+// publish отправляет сообщение и ждёт подтверждения брокера, subject - что публикуется, для текста ошибки
+func (c *confirmChannel) publish(exchange, key string, msg amqp.Publishing, subject string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.ensureChannel(); err != nil {
+		return err
+	}
+
+	err := c.ch.Publish(
+		exchange, // exchange
+		key,      // routing key
+		false,    // mandatory
+		false,    // immediate
+		msg,
+	)
+	if err != nil {
+		c.reset()
+		return err
+	}
+
+	select {
+	case confirm, ok := <-c.confirms:
+		if !ok {
+			c.reset()
+			return errors.New("канал закрыт до подтверждения публикации " + subject)
+		}
+		if !confirm.Ack {
+			return errors.New("брокер отклонил " + subject)
+		}
+		return nil
+	case <-time.After(confirmTimeout):
+		// Подтверждение могло прийти позже и сбить очередность - начинаем с нового канала
+		c.reset()
+		return errors.New("нет подтверждения публикации " + subject)
+	}
+}
+
+// close закрывает канал
+func (c *confirmChannel) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+func (c *confirmChannel) ensureChannel() error {
+	if c.ch != nil {
+		return nil
+	}
+
+	ch, err := c.r.NewChannel()
+	if err != nil {
+		return err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		log.Printf("Ошибка включения publisher confirms: %v", err)
+		return err
+	}
+
+	c.ch = ch
+	c.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return nil
+}
+
+func (c *confirmChannel) reset() {
+	if c.ch != nil {
+		c.ch.Close()
+	}
+	c.ch = nil
+	c.confirms = nil
+}
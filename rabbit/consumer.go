@@ -0,0 +1,134 @@
+package rabbit
+
+import (
+	"github.com/streadway/amqp"
+	"log"
+	"sync"
+	"time"
+)
+
+// Consumer - потребитель очереди, который переживает переподключения.
+// Воркеры читают один и тот же канал Deliveries, а супервизор подменяет под ним канал AMQP
+type Consumer struct {
+	r        *RabbitMQ
+	queue    string
+	tag      string
+	prefetch int
+
+	out  chan amqp.Delivery
+	done chan struct{}
+	once sync.Once
+
+	mu sync.Mutex
+	ch *amqp.Channel
+}
+
+// NewConsumer открывает канал с Qos и начинает потребление из очереди
+func (r *RabbitMQ) NewConsumer(queue, tag string, prefetch int) (*Consumer, error) {
+	c := &Consumer{
+		r:        r,
+		queue:    queue,
+		tag:      tag,
+		prefetch: prefetch,
+		out:      make(chan amqp.Delivery),
+		done:     make(chan struct{}),
+	}
+
+	deliveries, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	go c.run(deliveries)
+	return c, nil
+}
+
+// Deliveries - канал сообщений для воркеров. Закрывается после Cancel
+func (c *Consumer) Deliveries() <-chan amqp.Delivery {
+	return c.out
+}
+
+// Cancel прекращает потребление. Сообщения, которые брокер успел выдать, возвращаются в очередь
+func (c *Consumer) Cancel() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.ch != nil {
+			err = c.ch.Cancel(c.tag, false)
+		}
+	})
+	return err
+}
+
+// Close закрывает канал потребителя, неподтверждённые сообщения брокер вернёт в очередь
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ch == nil {
+		return nil
+	}
+	err := c.ch.Close()
+	c.ch = nil
+	return err
+}
+
+func (c *Consumer) open() (<-chan amqp.Delivery, error) {
+	ch, err := c.r.NewChannel()
+	if err != nil {
+		return nil, err
+	}
+	// Брокер не выдаёт больше prefetch неподтверждённых сообщений
+	if err := ch.Qos(c.prefetch, 0, false); err != nil {
+		log.Printf("Ошибка Qos: %v", err)
+		ch.Close()
+		return nil, err
+	}
+	deliveries, err := ch.Consume(c.queue, c.tag, false, false, false, false, nil)
+	if err != nil {
+		log.Printf("Ошибка потребителя: %v", err)
+		ch.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.ch = ch
+	c.mu.Unlock()
+	return deliveries, nil
+}
+
+// run пересылает сообщения воркерам и после обрыва связи открывает потребителя заново
+func (c *Consumer) run(deliveries <-chan amqp.Delivery) {
+	defer close(c.out)
+
+	for {
+		for msg := range deliveries {
+			select {
+			case c.out <- msg:
+			case <-c.done:
+				msg.Nack(false, true)
+			}
+		}
+
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		log.Printf("Канал потребителя %s закрыт, ожидание переподключения", c.queue)
+		for {
+			if !c.r.waitConnected(c.done) {
+				return
+			}
+			var err error
+			deliveries, err = c.open()
+			if err == nil {
+				log.Printf("Потребитель %s восстановлен", c.queue)
+				break
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package rabbit
+
+import (
+	"encoding/json"
+	"github.com/streadway/amqp"
+	"log"
+	"payment-service-go/models"
+)
+
+// EventsExchange - topic exchange событий жизненного цикла счетов
+const EventsExchange = "invoice_events"
+
+// EventPublisher публикует события в invoice_events с подтверждением от брокера (publisher confirms)
+type EventPublisher struct {
+	channel confirmChannel
+}
+
+// NewEventPublisher создаёт издателя событий
+func (r *RabbitMQ) NewEventPublisher() *EventPublisher {
+	return &EventPublisher{channel: confirmChannel{r: r}}
+}
+
+// Publish отправляет событие и ждёт подтверждения. Routing key - тип события
+func (p *EventPublisher) Publish(event models.InvoiceEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	err = p.channel.publish(EventsExchange, event.Type, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.ID,
+		Type:         event.Type,
+		Timestamp:    event.OccurredAt,
+		Body:         body,
+	}, "события "+event.Type)
+	if err != nil {
+		log.Printf("Ошибка публикации события %s: %v", event.Type, err)
+	}
+	return err
+}
+
+// Close закрывает канал издателя
+func (p *EventPublisher) Close() {
+	p.channel.close()
+}
@@ -3,28 +3,76 @@ package rabbit
 import (
 	"github.com/streadway/amqp"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type RabbitMQ struct {
+	url   string
+	retry RetryPolicy
+
+	mu      sync.RWMutex
 	conn    *amqp.Connection
 	channel *amqp.Channel
+	ready   chan struct{}   // закрыт, пока соединение установлено
+	requeue *confirmChannel // публикации Retry и DeadLetter с подтверждением брокера
+	health  Health
+	closed  int32
 }
 
-// NewRabbitMQ создаёт новое подключение к RabbitMQ
-func NewRabbitMQ(url string) (*RabbitMQ, error) {
+// NewRabbitMQ создаёт новое подключение к RabbitMQ и запускает супервизор переподключений
+func NewRabbitMQ(url string, retry RetryPolicy) (*RabbitMQ, error) {
+	conn, ch, err := connect(url, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan struct{})
+	close(ready)
+	r := &RabbitMQ{
+		url:     url,
+		retry:   retry,
+		conn:    conn,
+		channel: ch,
+		ready:   ready,
+		health:  Health{State: StateConnected, Since: time.Now()},
+	}
+	r.requeue = &confirmChannel{r: r}
+	go r.supervise(conn)
+
+	log.Printf("RabbitMQ настроен: exchange=invoices_exchange, queue=invoices, routing_key=invoice.create, dead_letter_queue=dead_letter_queue, повторы=%v", retry.Delays())
+	return r, nil
+}
+
+// connect подключается к брокеру и объявляет топологию
+func connect(url string, retry RetryPolicy) (*amqp.Connection, *amqp.Channel, error) {
 	conn, err := amqp.Dial(url)
 	if err != nil {
 		log.Printf("Ошибка подключения к RabbitMQ: %v", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	ch, err := conn.Channel()
 	if err != nil {
 		log.Printf("Ошибка создания канала: %v", err)
 		conn.Close()
-		return nil, err
+		return nil, nil, err
+	}
+
+	if err := declareTopology(ch, retry); err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, nil, err
 	}
 
+	return conn, ch, nil
+}
+
+// declareTopology объявляет exchange, очереди и привязки. Повторный вызов безопасен
+func declareTopology(ch *amqp.Channel, retry RetryPolicy) error {
+	var err error
+
 	// Объявляем exchange
 	err = ch.ExchangeDeclare(
 		"invoices_exchange", // имя exchange
@@ -37,9 +85,7 @@ func NewRabbitMQ(url string) (*RabbitMQ, error) {
 	)
 	if err != nil {
 		log.Printf("Ошибка объявления exchange: %v", err)
-		ch.Close()
-		conn.Close()
-		return nil, err
+		return err
 	}
 
 	// Объявляем dead-letter exchange
@@ -54,9 +100,7 @@ func NewRabbitMQ(url string) (*RabbitMQ, error) {
 	)
 	if err != nil {
 		log.Printf("Ошибка объявления dead-letter exchange: %v", err)
-		ch.Close()
-		conn.Close()
-		return nil, err
+		return err
 	}
 
 	// Объявляем очередь
@@ -73,9 +117,7 @@ func NewRabbitMQ(url string) (*RabbitMQ, error) {
 	)
 	if err != nil {
 		log.Printf("Ошибка объявления очереди: %v", err)
-		ch.Close()
-		conn.Close()
-		return nil, err
+		return err
 	}
 
 	// Объявляем dead-letter очередь
@@ -89,9 +131,7 @@ func NewRabbitMQ(url string) (*RabbitMQ, error) {
 	)
 	if err != nil {
 		log.Printf("Ошибка объявления dead-letter очереди: %v", err)
-		ch.Close()
-		conn.Close()
-		return nil, err
+		return err
 	}
 
 	// Привязываем dead-letter очередь к dead-letter exchange
@@ -104,9 +144,7 @@ func NewRabbitMQ(url string) (*RabbitMQ, error) {
 	)
 	if err != nil {
 		log.Printf("Ошибка привязки dead-letter очереди: %v", err)
-		ch.Close()
-		conn.Close()
-		return nil, err
+		return err
 	}
 
 	// Привязываем очередь к exchange
@@ -119,18 +157,41 @@ func NewRabbitMQ(url string) (*RabbitMQ, error) {
 	)
 	if err != nil {
 		log.Printf("Ошибка привязки очереди: %v", err)
-		ch.Close()
-		conn.Close()
-		return nil, err
+		return err
+	}
+
+	// Объявляем topic exchange событий жизненного цикла счетов
+	err = ch.ExchangeDeclare(
+		EventsExchange, // имя exchange
+		"topic",        // тип
+		true,           // durable
+		false,          // auto-deleted
+		false,          // internal
+		false,          // no-wait
+		nil,            // args
+	)
+	if err != nil {
+		log.Printf("Ошибка объявления exchange событий: %v", err)
+		return err
+	}
+
+	// Объявляем очереди ожидания для повторов
+	err = declareDelayQueues(ch, retry)
+	if err != nil {
+		log.Printf("Ошибка объявления очередей ожидания: %v", err)
+		return err
 	}
 
-	log.Println("RabbitMQ настроен: exchange=invoices_exchange, queue=invoices, routing_key=invoice.create, dead_letter_queue=dead_letter_queue")
-	return &RabbitMQ{conn: conn, channel: ch}, nil
+	return nil
 }
 
-// NewChannel создаёт новый канал
+// NewChannel создаёт новый канал на текущем соединении
 func (r *RabbitMQ) NewChannel() (*amqp.Channel, error) {
-	ch, err := r.conn.Channel()
+	r.mu.RLock()
+	conn := r.conn
+	r.mu.RUnlock()
+
+	ch, err := conn.Channel()
 	if err != nil {
 		log.Printf("Ошибка создания нового канала: %v", err)
 		return nil, err
@@ -138,10 +199,26 @@ func (r *RabbitMQ) NewChannel() (*amqp.Channel, error) {
 	return ch, nil
 }
 
+// QueueDepth возвращает число готовых к выдаче сообщений в очереди.
+// Запрос идёт через отдельный канал: ошибка QueueInspect закрывает канал, на котором выполнена
+func (r *RabbitMQ) QueueDepth(queue string) (int, error) {
+	ch, err := r.NewChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueInspect(queue)
+	if err != nil {
+		return 0, err
+	}
+	return q.Messages, nil
+}
+
 // Consume запускает чтение сообщений из очереди
 func (r *RabbitMQ) Consume(queue string) (<-chan amqp.Delivery, error) {
 	log.Printf("Начинаем потребление из очереди %s", queue)
-	return r.channel.Consume(
+	return r.currentChannel().Consume(
 		queue, // имя очереди
 		"",    // consumer tag
 		false, // auto-ack
@@ -152,8 +229,21 @@ func (r *RabbitMQ) Consume(queue string) (<-chan amqp.Delivery, error) {
 	)
 }
 
-// Close закрывает соединение
+// currentChannel - служебный канал текущего соединения
+func (r *RabbitMQ) currentChannel() *amqp.Channel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.channel
+}
+
+// Close закрывает соединение и останавливает супервизор
 func (r *RabbitMQ) Close() {
+	atomic.StoreInt32(&r.closed, 1)
+	r.requeue.close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.channel != nil {
 		if err := r.channel.Close(); err != nil {
 			log.Printf("Ошибка закрытия канала: %v", err)
@@ -164,4 +254,5 @@ func (r *RabbitMQ) Close() {
 			log.Printf("Ошибка закрытия соединения: %v", err)
 		}
 	}
+	r.health = Health{State: StateClosed, Since: time.Now(), Reconnects: r.health.Reconnects}
 }
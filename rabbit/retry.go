@@ -0,0 +1,185 @@
+package rabbit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"os"
+	"payment-service-go/metrics"
+	"payment-service-go/models"
+	"payment-service-go/redact"
+	"strconv"
+	"time"
+)
+
+const (
+	// HeaderAttempt - номер повторной попытки обработки сообщения
+	HeaderAttempt = "x-attempt"
+	// HeaderDeadReason - причина отправки сообщения в dead_letter_queue
+	HeaderDeadReason = "x-dead-reason"
+)
+
+// RetryPolicy - расписание отложенных повторов: BaseDelay, BaseDelay*Factor, ...
+type RetryPolicy struct {
+	BaseDelay   time.Duration
+	Factor      int
+	MaxAttempts int
+}
+
+// LoadRetryPolicy читает расписание повторов из окружения
+func LoadRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{BaseDelay: 5 * time.Second, Factor: 2, MaxAttempts: 5}
+
+	if value := os.Getenv("RETRY_BASE_DELAY"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			policy.BaseDelay = d
+		} else {
+			log.Printf("Некорректное значение RETRY_BASE_DELAY=%s", value)
+		}
+	}
+	if value := os.Getenv("RETRY_FACTOR"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 1 {
+			policy.Factor = n
+		} else {
+			log.Printf("Некорректное значение RETRY_FACTOR=%s", value)
+		}
+	}
+	if value := os.Getenv("RETRY_MAX_ATTEMPTS"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			policy.MaxAttempts = n
+		} else {
+			log.Printf("Некорректное значение RETRY_MAX_ATTEMPTS=%s", value)
+		}
+	}
+	return policy
+}
+
+// Delays возвращает задержку для каждой попытки
+func (p RetryPolicy) Delays() []time.Duration {
+	delays := make([]time.Duration, p.MaxAttempts)
+	delay := p.BaseDelay
+	for i := range delays {
+		delays[i] = delay
+		delay *= time.Duration(p.Factor)
+	}
+	return delays
+}
+
+// delayQueueName - очередь ожидания для одной ступени задержки.
+// В каждой очереди у всех сообщений одинаковый TTL, поэтому голова очереди не блокирует остальных
+func delayQueueName(delay time.Duration) string {
+	return fmt.Sprintf("invoices_delay_%dms", delay.Milliseconds())
+}
+
+// declareDelayQueues объявляет очереди ожидания, которые по истечении TTL возвращают сообщения в invoices
+func declareDelayQueues(ch *amqp.Channel, policy RetryPolicy) error {
+	for _, delay := range policy.Delays() {
+		_, err := ch.QueueDeclare(
+			delayQueueName(delay), // имя очереди
+			true,                  // durable
+			false,                 // auto-deleted
+			false,                 // exclusive
+			false,                 // no-wait
+			amqp.Table{
+				"x-dead-letter-exchange":    "invoices_exchange",
+				"x-dead-letter-routing-key": "invoice.create",
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Attempt возвращает номер попытки из заголовка сообщения, 0 - первая доставка
+func Attempt(msg amqp.Delivery) int {
+	switch v := msg.Headers[HeaderAttempt].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// Retry откладывает сообщение в очередь ожидания или, если попытки исчерпаны, отправляет в dead_letter_queue.
+// Исходное сообщение подтверждается только после того, как брокер подтвердил публикацию
+func (r *RabbitMQ) Retry(msg amqp.Delivery, reason string) error {
+	attempt := Attempt(msg)
+	delays := r.retry.Delays()
+	if attempt >= len(delays) {
+		return r.DeadLetter(msg, fmt.Sprintf("попытки исчерпаны (%d): %s", attempt, reason))
+	}
+
+	delay := delays[attempt]
+	headers := copyHeaders(msg.Headers)
+	headers[HeaderAttempt] = int32(attempt + 1)
+
+	// exchange по умолчанию, routing key = имя очереди
+	err := r.requeue.publish("", delayQueueName(delay), amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Expiration:   strconv.FormatInt(delay.Milliseconds(), 10),
+		Body:         msg.Body,
+	}, "сообщения в "+delayQueueName(delay))
+	if err != nil {
+		log.Printf("Ошибка публикации в очередь ожидания: %v", err)
+		return err
+	}
+
+	log.Printf("Сообщение отложено на %s, попытка %d", delay, attempt+1)
+	metrics.Messages.WithLabelValues(metrics.OutcomeRetry).Inc()
+	return msg.Ack(false)
+}
+
+// DeadLetter отправляет сообщение в dead_letter_queue с причиной в заголовке и подтверждает исходное
+// после подтверждения публикации брокером.
+// Ключи обменников из задач старого формата в dead_letter_queue не попадают
+func (r *RabbitMQ) DeadLetter(msg amqp.Delivery, reason string) error {
+	headers := copyHeaders(msg.Headers)
+	headers[HeaderAttempt] = int32(Attempt(msg))
+	headers[HeaderDeadReason] = reason
+
+	err := r.requeue.publish("dead_letter_exchange", "dead_letter", amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Body:         withoutCredentials(msg.Body),
+	}, "сообщения в dead_letter_exchange")
+	if err != nil {
+		log.Printf("Ошибка публикации в dead_letter_exchange: %v", err)
+		return err
+	}
+
+	log.Printf("Сообщение отправлено в dead_letter_queue: %s", reason)
+	metrics.Messages.WithLabelValues(metrics.OutcomeDeadLetter).Inc()
+	return msg.Ack(false)
+}
+
+// withoutCredentials убирает ключи обменников из тела задачи. Тело, которое не разбирается как задача,
+// маскируется как текст
+func withoutCredentials(body []byte) []byte {
+	var task models.InvoiceTask
+	if err := json.Unmarshal(body, &task); err != nil {
+		return []byte(redact.Default().Text(string(body)))
+	}
+	stripped, err := json.Marshal(task.WithoutCredentials())
+	if err != nil {
+		return []byte(redact.Default().Text(string(body)))
+	}
+	return stripped
+}
+
+func copyHeaders(src amqp.Table) amqp.Table {
+	headers := amqp.Table{}
+	for key, value := range src {
+		headers[key] = value
+	}
+	return headers
+}
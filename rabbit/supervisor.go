@@ -0,0 +1,124 @@
+package rabbit
+
+import (
+	"github.com/streadway/amqp"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateClosed       = "closed"
+)
+
+// Health - состояние подключения к брокеру
+type Health struct {
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	LastError  string    `json:"last_error,omitempty"`
+	Reconnects int       `json:"reconnects"`
+}
+
+// Health возвращает текущее состояние подключения
+func (r *RabbitMQ) Health() Health {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.health
+}
+
+// supervise следит за NotifyClose соединения и служебного канала и переподключается
+// с экспоненциальной задержкой, заново объявляя exchange, очереди и DLQ
+func (r *RabbitMQ) supervise(conn *amqp.Connection) {
+	for {
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chClosed := r.currentChannel().NotifyClose(make(chan *amqp.Error, 1))
+
+		var cause *amqp.Error
+		select {
+		case cause = <-connClosed:
+		case cause = <-chClosed:
+			// Закрыт только служебный канал - открываем новый на живом соединении
+			if atomic.LoadInt32(&r.closed) == 0 && !conn.IsClosed() {
+				if ch, err := conn.Channel(); err == nil {
+					r.mu.Lock()
+					r.channel = ch
+					r.mu.Unlock()
+					log.Printf("Служебный канал RabbitMQ пересоздан: %v", cause)
+					continue
+				}
+			}
+		}
+		if atomic.LoadInt32(&r.closed) == 1 {
+			return
+		}
+
+		reason := "соединение закрыто"
+		if cause != nil {
+			reason = cause.Error()
+		}
+		log.Printf("Соединение с RabbitMQ потеряно: %s", reason)
+		conn.Close()
+
+		r.mu.Lock()
+		r.ready = make(chan struct{})
+		r.health = Health{State: StateReconnecting, Since: time.Now(), LastError: reason, Reconnects: r.health.Reconnects}
+		r.mu.Unlock()
+
+		conn = r.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect подключается заново, пока не получится или пока не вызван Close
+func (r *RabbitMQ) reconnect() *amqp.Connection {
+	backoff := time.Second
+	const maxBackoff = 30 * time.Second
+
+	for attempt := 1; ; attempt++ {
+		if atomic.LoadInt32(&r.closed) == 1 {
+			return nil
+		}
+
+		conn, ch, err := connect(r.url, r.retry)
+		if err == nil {
+			r.mu.Lock()
+			r.conn = conn
+			r.channel = ch
+			r.health = Health{State: StateConnected, Since: time.Now(), Reconnects: r.health.Reconnects + 1}
+			close(r.ready)
+			r.mu.Unlock()
+
+			log.Printf("RabbitMQ переподключен, попытка %d", attempt)
+			return conn
+		}
+
+		r.mu.Lock()
+		r.health.LastError = err.Error()
+		r.mu.Unlock()
+
+		log.Printf("Переподключение к RabbitMQ не удалось (попытка %d), повтор через %s", attempt, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// waitConnected ждёт установленного соединения. Возвращает false, если закрыт stop
+func (r *RabbitMQ) waitConnected(stop <-chan struct{}) bool {
+	r.mu.RLock()
+	ready := r.ready
+	r.mu.RUnlock()
+
+	select {
+	case <-ready:
+		return true
+	case <-stop:
+		return false
+	}
+}
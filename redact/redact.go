@@ -0,0 +1,269 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maskPrefix - начало маскированного значения, повторно такие значения не маскируются
+const maskPrefix = "***"
+
+var (
+	cardPattern = regexp.MustCompile(`\b\d{13,19}\b`)
+	pairPattern = regexp.MustCompile(`(?i)("?)(private_key|api_key|secret_key|token)("?\s*[=:]\s*"?)([^&\s",}]+)`)
+)
+
+// Redactor маскирует секреты и персональные данные перед записью в логи и ClickHouse.
+// Одно и то же значение всегда маскируется одинаково, поэтому записи можно сопоставлять
+type Redactor struct {
+	key   []byte
+	rules map[string]Rules
+}
+
+// New - конструктор. rules дополняют встроенные правила
+func New(key []byte, rules map[string]Rules) *Redactor {
+	merged := make(map[string]Rules, len(defaultRules)+len(rules))
+	for name, r := range defaultRules {
+		merged[name] = r
+	}
+	for name, r := range rules {
+		merged[name] = merged[name].merge(r)
+	}
+	return &Redactor{key: key, rules: merged}
+}
+
+// Load создаёт маскировщик из окружения: REDACTION_KEY - ключ HMAC для масок, обязателен,
+// REDACTION_RULES - JSON-файл с правилами по имени обменника
+func Load() (*Redactor, error) {
+	key := []byte(os.Getenv("REDACTION_KEY"))
+	if len(key) == 0 {
+		// Со случайным ключом маски не совпадут между перезапусками и экземплярами сервиса
+		return nil, errors.New("REDACTION_KEY не задан")
+	}
+
+	var rules map[string]Rules
+	if path := os.Getenv("REDACTION_RULES"); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &rules); err != nil {
+			return nil, err
+		}
+	}
+	return New(key, rules), nil
+}
+
+var (
+	defaultMu sync.RWMutex
+	fallback  = New(randomKey(), nil)
+)
+
+// Default возвращает маскировщик, которым пользуются все пути логирования
+func Default() *Redactor {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return fallback
+}
+
+// SetDefault заменяет маскировщик по умолчанию, вызывается при старте
+func SetDefault(r *Redactor) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	fallback = r
+}
+
+// Mask заменяет значение маской: последние 4 символа и отпечаток HMAC
+func (r *Redactor) Mask(value string) string {
+	if value == "" || strings.HasPrefix(value, maskPrefix) {
+		return value
+	}
+	h := hmac.New(sha256.New, r.key)
+	h.Write([]byte(value))
+	fingerprint := hex.EncodeToString(h.Sum(nil))[:12]
+
+	if len(value) >= 8 {
+		return maskPrefix + value[len(value)-4:] + "#" + fingerprint
+	}
+	return maskPrefix + "#" + fingerprint
+}
+
+func (r *Redactor) rulesFor(provider string) Rules {
+	rules := r.rules[AnyProvider]
+	if provider != "" && provider != AnyProvider {
+		rules = rules.merge(r.rules[provider])
+	}
+	return rules
+}
+
+// URL маскирует параметры query string
+func (r *Redactor) URL(provider, raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return r.Text(raw)
+	}
+	u.RawQuery = r.query(r.rulesFor(provider), u.RawQuery)
+	return u.String()
+}
+
+// Params маскирует параметры запроса: JSON-тело или url-encoded строку
+func (r *Redactor) Params(provider, params string) string {
+	trimmed := strings.TrimSpace(params)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return r.Body(provider, params)
+	}
+	if strings.Contains(params, "=") {
+		return r.query(r.rulesFor(provider), params)
+	}
+	return r.Text(params)
+}
+
+// Body маскирует JSON-ответ или тело запроса по путям и именам полей.
+// Не JSON маскируется как текст
+func (r *Redactor) Body(provider, body string) string {
+	// Числа остаются json.Number: через float64 большие идентификаторы и суммы теряют точность
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil || decoder.Decode(&struct{}{}) != io.EOF {
+		return r.Text(body)
+	}
+
+	rules := r.rulesFor(provider)
+	for _, path := range rules.JSON {
+		data = r.maskPath(data, strings.Split(path, "."))
+	}
+	if len(rules.Keys) > 0 {
+		data = r.maskKeys(data, toSet(rules.Keys))
+	}
+
+	out, err := json.Marshal(data)
+	if err != nil {
+		return r.Text(body)
+	}
+	return string(out)
+}
+
+// Headers возвращает копию заголовков с маскированными значениями
+func (r *Redactor) Headers(provider string, header http.Header) http.Header {
+	masked := header.Clone()
+	for _, name := range r.rulesFor(provider).Headers {
+		values := masked.Values(name)
+		for i, value := range values {
+			values[i] = r.Mask(value)
+		}
+	}
+	return masked
+}
+
+// Text маскирует номера карт и пары ключ=значение с секретами в произвольном тексте
+func (r *Redactor) Text(s string) string {
+	s = pairPattern.ReplaceAllStringFunc(s, func(match string) string {
+		parts := pairPattern.FindStringSubmatch(match)
+		return parts[1] + parts[2] + parts[3] + r.Mask(parts[4])
+	})
+	return cardPattern.ReplaceAllStringFunc(s, r.Mask)
+}
+
+// query маскирует значения параметров, сохраняя порядок и остальные параметры как есть
+func (r *Redactor) query(rules Rules, raw string) string {
+	names := toSet(rules.Query)
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		name, value, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil && names[unescaped] {
+			if v, err := url.QueryUnescape(value); err == nil {
+				value = v
+			}
+			parts[i] = name + "=" + r.Mask(value)
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func (r *Redactor) maskPath(data interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return r.maskValue(data)
+	}
+
+	switch node := data.(type) {
+	case map[string]interface{}:
+		if path[0] == "*" {
+			for key, value := range node {
+				node[key] = r.maskPath(value, path[1:])
+			}
+		} else if value, ok := node[path[0]]; ok {
+			node[path[0]] = r.maskPath(value, path[1:])
+		}
+	case []interface{}:
+		if path[0] == "*" {
+			for i, value := range node {
+				node[i] = r.maskPath(value, path[1:])
+			}
+		} else if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(node) {
+			node[i] = r.maskPath(node[i], path[1:])
+		}
+	}
+	return data
+}
+
+func (r *Redactor) maskKeys(data interface{}, keys map[string]bool) interface{} {
+	switch node := data.(type) {
+	case map[string]interface{}:
+		for key, value := range node {
+			if keys[key] {
+				node[key] = r.maskValue(value)
+			} else {
+				node[key] = r.maskKeys(value, keys)
+			}
+		}
+	case []interface{}:
+		for i, value := range node {
+			node[i] = r.maskKeys(value, keys)
+		}
+	}
+	return data
+}
+
+func (r *Redactor) maskValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return r.Mask(v)
+	case json.Number:
+		return r.Mask(v.String())
+	default:
+		raw, _ := json.Marshal(v)
+		return r.Mask(string(raw))
+	}
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
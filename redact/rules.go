@@ -0,0 +1,42 @@
+package redact
+
+// Rules - что маскировать в запросах к обменнику
+type Rules struct {
+	Headers []string `json:"headers"` // заголовки
+	Query   []string `json:"query"`   // параметры query string и form
+	JSON    []string `json:"json"`    // пути в JSON, "*" - любой элемент, например order.*.cart
+	Keys    []string `json:"keys"`    // поля JSON с таким именем на любой глубине
+}
+
+// AnyProvider - ключ правил, которые действуют для всех обменников
+const AnyProvider = "*"
+
+// defaultRules - ключи доступа для всех обменников и реквизиты известных обменников
+var defaultRules = map[string]Rules{
+	AnyProvider: {
+		Headers: []string{"Authorization", "X-APIKEY", "X-API-Key", "X-SIGNATURE", "Api-Secret"},
+		Query:   []string{"private_key", "api_key", "secret_key", "token"},
+		Keys:    []string{"api_key", "secret_key", "private_key"},
+	},
+	"Bitloga": {
+		JSON: []string{"requisites", "name", "surname"},
+	},
+	"Racks": {
+		JSON: []string{"order.*.cart"},
+	},
+	"Greengo": {
+		JSON: []string{"items.*.wallet_payment", "wallet", "wallet_payment"},
+	},
+	"LuckyPay": {
+		JSON: []string{"holder_account", "holder_name", "customer_payment_account", "orders.items.*.holder_account", "orders.items.*.holder_name"},
+	},
+}
+
+func (r Rules) merge(other Rules) Rules {
+	return Rules{
+		Headers: append(append([]string{}, r.Headers...), other.Headers...),
+		Query:   append(append([]string{}, r.Query...), other.Query...),
+		JSON:    append(append([]string{}, r.JSON...), other.JSON...),
+		Keys:    append(append([]string{}, r.Keys...), other.Keys...),
+	}
+}
@@ -0,0 +1,117 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// mountBitloga: создание и отмена - POST /api/v1/ с action invoice/cancel, статус - POST /api/v1/order/
+// с action details. Все запросы подписаны X-SIGNATURE = HMAC-SHA512 тела на SecretKey
+func (s *Server) mountBitloga() {
+	s.mux.HandleFunc("POST /bitloga/api/v1/{$}", s.bitlogaAction)
+	s.mux.HandleFunc("POST /bitloga/api/v1/order/{$}", s.bitlogaDetails)
+}
+
+// bitlogaRequest читает тело и проверяет X-APIKEY и X-SIGNATURE
+func (s *Server) bitlogaRequest(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	body, err := readBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": err.Error()})
+		return nil, false
+	}
+
+	creds := s.credentials(Bitloga)
+	if creds.APIKey != "" && r.Header.Get("X-APIKEY") != creds.APIKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"success": false, "message": "invalid api key"})
+		return nil, false
+	}
+	if creds.SecretKey != "" && strings.ToLower(r.Header.Get("X-SIGNATURE")) != signSHA512(creds.SecretKey, body) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"success": false, "message": "invalid signature"})
+		return nil, false
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "invalid json"})
+		return nil, false
+	}
+	return data, true
+}
+
+func (s *Server) bitlogaAction(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.bitlogaRequest(w, r)
+	if !ok {
+		return
+	}
+
+	switch data["action"] {
+	case "invoice":
+		s.serve(w, r, Bitloga, OpCreate, func(sc Scenario) reply {
+			uniqueID := fmt.Sprint(data["uniqueid"])
+			amount, _ := data["amount"].(float64)
+			if data["uniqueid"] == nil || amount <= 0 {
+				return reply{http.StatusOK, map[string]interface{}{"success": false, "message": "uniqueid and amount are required"}}
+			}
+			callbackURL, _ := data["callbackurl"].(string)
+
+			o := s.newOrder(Bitloga, Order{
+				InvoiceID: uniqueID,
+				Amount:    amount,
+				Method:    fmt.Sprint(data["paysys"]),
+				Callback:  callbackURL,
+				signKey:   s.credentials(Bitloga).SecretKey,
+			}, sc)
+			return reply{http.StatusOK, map[string]interface{}{
+				"success":        true,
+				"invoiceid":      o.ID,
+				"uniqueid":       o.InvoiceID,
+				"requisites":     o.Requisites,
+				"amount_payable": o.Amount,
+			}}
+		})
+	case "cancel":
+		s.serve(w, r, Bitloga, OpCancel, func(sc Scenario) reply {
+			id, _ := data["invoiceid"].(string)
+			if _, err := s.cancelPending(Bitloga, id, lifecycles[Bitloga].canceled); err != nil {
+				return reply{http.StatusOK, map[string]interface{}{"success": false, "message": err.Error()}}
+			}
+			return reply{http.StatusOK, map[string]interface{}{"success": true}}
+		})
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "unknown action"})
+	}
+}
+
+func (s *Server) bitlogaDetails(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.bitlogaRequest(w, r)
+	if !ok {
+		return
+	}
+	if data["action"] != "details" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "unknown action"})
+		return
+	}
+
+	s.serve(w, r, Bitloga, OpStatus, func(sc Scenario) reply {
+		// uniqueid приходит числом при проверке статуса и строкой при создании заявки
+		uniqueID := fmt.Sprint(data["uniqueid"])
+		if n, ok := data["uniqueid"].(float64); ok {
+			uniqueID = fmt.Sprintf("%.0f", n)
+		}
+
+		o, found := s.findByInvoice(Bitloga, uniqueID)
+		if !found {
+			return reply{http.StatusNotFound, map[string]interface{}{"success": false, "message": errOrderNotFound.Error()}}
+		}
+		return reply{http.StatusOK, map[string]interface{}{
+			"success":        true,
+			"invoiceid":      o.ID,
+			"uniqueid":       o.InvoiceID,
+			"status":         o.Status,
+			"requisites":     o.Requisites,
+			"amount_payable": o.Amount,
+		}}
+	})
+}
@@ -0,0 +1,56 @@
+package sandbox
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// LoadConfig читает настройки песочницы из окружения:
+// SANDBOX_API_KEY и SANDBOX_SECRET_KEY - ключи всех обменников, SANDBOX_<ОБМЕННИК>_API_KEY и
+// SANDBOX_<ОБМЕННИК>_SECRET_KEY переопределяют их для одного обменника; SANDBOX_PAY_AFTER (0 - не
+// оплачивать сами), SANDBOX_ORDER_TTL, SANDBOX_SCENARIOS - JSON-файл со сценариями
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		Credentials: make(map[string]Credentials),
+		PayAfter:    envDuration("SANDBOX_PAY_AFTER", 30*time.Second),
+		OrderTTL:    envDuration("SANDBOX_ORDER_TTL", 20*time.Minute),
+	}
+
+	apiKey := os.Getenv("SANDBOX_API_KEY")
+	secretKey := os.Getenv("SANDBOX_SECRET_KEY")
+	for _, p := range Providers {
+		creds := Credentials{APIKey: apiKey, SecretKey: secretKey}
+		prefix := "SANDBOX_" + strings.ToUpper(p) + "_"
+		if v := os.Getenv(prefix + "API_KEY"); v != "" {
+			creds.APIKey = v
+		}
+		if v := os.Getenv(prefix + "SECRET_KEY"); v != "" {
+			creds.SecretKey = v
+		}
+		cfg.Credentials[p] = creds
+	}
+
+	if path := os.Getenv("SANDBOX_SCENARIOS"); path != "" {
+		scenarios, err := LoadScenarios(path)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Scenarios = scenarios
+	}
+	return cfg, nil
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Printf("Некорректное значение %s=%s", key, value)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,97 @@
+package sandbox
+
+import (
+	"net/http"
+	"strings"
+)
+
+// mountControl - служебные маршруты для QA:
+//
+//	GET    /_sandbox/orders                       - все заявки
+//	POST   /_sandbox/orders/{provider}/{id}/pay    - оплатить заявку
+//	POST   /_sandbox/orders/{provider}/{id}/cancel - отменить заявку по времени
+//	POST   /_sandbox/orders/{provider}/{id}/status?status= - выставить статус обменника
+//	GET    /_sandbox/scenarios                    - действующие сценарии
+//	POST   /_sandbox/scenarios                    - добавить сценарий или массив сценариев
+//	DELETE /_sandbox/scenarios                    - удалить сценарии
+//	POST   /_sandbox/reset                        - удалить заявки и сценарии
+func (s *Server) mountControl() {
+	s.mux.HandleFunc("GET /_sandbox/orders", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Orders())
+	})
+	s.mux.HandleFunc("POST /_sandbox/orders/{provider}/{id}/{action}", s.controlOrder)
+
+	s.mux.HandleFunc("GET /_sandbox/scenarios", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Scenarios())
+	})
+	s.mux.HandleFunc("POST /_sandbox/scenarios", s.controlAddScenarios)
+	s.mux.HandleFunc("DELETE /_sandbox/scenarios", func(w http.ResponseWriter, r *http.Request) {
+		s.ClearScenarios()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	s.mux.HandleFunc("POST /_sandbox/reset", func(w http.ResponseWriter, r *http.Request) {
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (s *Server) controlOrder(w http.ResponseWriter, r *http.Request) {
+	provider := providerName(r.PathValue("provider"))
+	if provider == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "неизвестный обменник"})
+		return
+	}
+	id := r.PathValue("id")
+
+	var err error
+	switch r.PathValue("action") {
+	case "pay":
+		err = s.Pay(provider, id)
+	case "cancel":
+		err = s.Cancel(provider, id)
+	case "status":
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "не задан status"})
+			return
+		}
+		err = s.SetStatus(provider, id, status)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "неизвестное действие"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	o, _ := s.Order(provider, id)
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) controlAddScenarios(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	scenarios, err := parseScenarios(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	for _, sc := range scenarios {
+		s.AddScenario(sc)
+	}
+	writeJSON(w, http.StatusOK, s.Scenarios())
+}
+
+// providerName приводит имя обменника из URL к имени в реестре
+func providerName(name string) string {
+	for _, p := range Providers {
+		if strings.EqualFold(p, name) {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// mountGreengo: создание, пакетная проверка и отмена заявок, ключ в заголовке Api-Secret. Callback Greengo не шлёт
+func (s *Server) mountGreengo() {
+	s.mux.HandleFunc("POST /greengo/api/v2/order/create", s.greengoCreate)
+	s.mux.HandleFunc("POST /greengo/api/v2/order/check/", s.greengoCheck)
+	s.mux.HandleFunc("POST /greengo/api/v2/order/cancel/", s.greengoCancel)
+}
+
+// greengoRequest проверяет Api-Secret и разбирает тело в v
+func (s *Server) greengoRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if key := s.credentials(Greengo).APIKey; key != "" && r.Header.Get("Api-Secret") != key {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"response": "invalid api secret"})
+		return false
+	}
+
+	body, err := readBody(r)
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"response": "invalid json"})
+		return false
+	}
+	return true
+}
+
+func (s *Server) greengoCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PaymentMethod string  `json:"payment_method"`
+		Wallet        string  `json:"wallet"`
+		FromAmount    float64 `json:"from_amount"`
+	}
+	if !s.greengoRequest(w, r, &req) {
+		return
+	}
+
+	s.serve(w, r, Greengo, OpCreate, func(sc Scenario) reply {
+		if req.FromAmount <= 0 {
+			return reply{http.StatusOK, map[string]interface{}{"response": "from_amount is required"}}
+		}
+
+		o := s.newOrder(Greengo, Order{Amount: req.FromAmount, Method: req.PaymentMethod}, sc)
+		return reply{http.StatusOK, map[string]interface{}{
+			"response": "success",
+			"items": []interface{}{map[string]interface{}{
+				"order_id":       o.ID,
+				"wallet_payment": o.Requisites,
+				"amount_payable": formatAmount(o.Amount),
+				"order_status":   o.Status,
+			}},
+		}}
+	})
+}
+
+func (s *Server) greengoCheck(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OrderID []int64 `json:"order_id"`
+	}
+	if !s.greengoRequest(w, r, &req) {
+		return
+	}
+
+	s.serve(w, r, Greengo, OpStatus, func(sc Scenario) reply {
+		orders := make([]interface{}, 0, len(req.OrderID))
+		for _, id := range req.OrderID {
+			o, ok := s.Order(Greengo, fmt.Sprintf("%d", id))
+			if !ok {
+				continue
+			}
+			orders = append(orders, map[string]interface{}{
+				"order_id":       id,
+				"order_status":   o.Status,
+				"amount_payable": formatAmount(o.Amount),
+			})
+		}
+		return reply{http.StatusOK, map[string]interface{}{
+			"response": "success",
+			"data":     map[string]interface{}{"orders": orders},
+		}}
+	})
+}
+
+func (s *Server) greengoCancel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OrderID int64 `json:"order_id"`
+	}
+	if !s.greengoRequest(w, r, &req) {
+		return
+	}
+
+	s.serve(w, r, Greengo, OpCancel, func(sc Scenario) reply {
+		if _, err := s.cancelPending(Greengo, fmt.Sprintf("%d", req.OrderID), lifecycles[Greengo].canceled); err != nil {
+			return reply{http.StatusOK, map[string]interface{}{"response": err.Error()}}
+		}
+		return reply{http.StatusOK, map[string]interface{}{"response": "success"}}
+	})
+}
@@ -0,0 +1,178 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Методы оплаты LuckyPay, которые передаёт сервис
+var luckyPayMethods = map[string]string{
+	"8fe3669a-a448-4053-bc4b-43bb51cb3e9d": "Банковская карта",
+	"2ec6dbd6-49a5-45d0-bd6d-b0134ee4639a": "СБП",
+}
+
+// mountLuckyPay: создание и список заявок - /api/v1/order/, отмена - /api/v1/order/{id}/cancel.
+// Ключ в заголовке X-API-Key, callback LuckyPay не шлёт
+func (s *Server) mountLuckyPay() {
+	s.mux.HandleFunc("POST /luckypay/api/v1/order/{$}", s.luckyPayCreate)
+	s.mux.HandleFunc("GET /luckypay/api/v1/order/{$}", s.luckyPayList)
+	s.mux.HandleFunc("POST /luckypay/api/v1/order/{id}/cancel", s.luckyPayCancel)
+}
+
+func (s *Server) luckyPayAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if key := s.credentials(LuckyPay).APIKey; key != "" && r.Header.Get("X-API-Key") != key {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"success": false, "message": "invalid api key"})
+		return false
+	}
+	return true
+}
+
+func (s *Server) luckyPayCreate(w http.ResponseWriter, r *http.Request) {
+	if !s.luckyPayAuthorized(w, r) {
+		return
+	}
+
+	var req struct {
+		ClientOrderID   string `json:"client_order_id"`
+		OrderSide       string `json:"order_side"`
+		PaymentMethodID string `json:"payment_method_id"`
+		Amount          string `json:"amount"`
+	}
+	body, err := readBody(r)
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": "invalid json"})
+		return
+	}
+
+	s.serve(w, r, LuckyPay, OpCreate, func(sc Scenario) reply {
+		method, ok := luckyPayMethods[req.PaymentMethodID]
+		if !ok {
+			return reply{http.StatusUnprocessableEntity, map[string]interface{}{"success": false, "message": "unknown payment method"}}
+		}
+		amount, err := strconv.ParseFloat(req.Amount, 64)
+		if err != nil || amount <= 0 || req.ClientOrderID == "" {
+			return reply{http.StatusUnprocessableEntity, map[string]interface{}{"success": false, "message": "client_order_id and amount are required"}}
+		}
+
+		o := s.newOrder(LuckyPay, Order{InvoiceID: req.ClientOrderID, Amount: amount, Method: method}, sc)
+		return reply{http.StatusCreated, luckyPayOrder(o)}
+	})
+}
+
+// luckyPayList отдаёт страницу заявок с фильтрами client_order_id, order_status и окном updated_from/updated_to
+func (s *Server) luckyPayList(w http.ResponseWriter, r *http.Request) {
+	if !s.luckyPayAuthorized(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	size, _ := strconv.Atoi(query.Get("size"))
+	if size < 1 || size > 100 {
+		size = 100
+	}
+
+	var from, to time.Time
+	var err error
+	if v := query.Get("updated_from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"success": false, "message": "invalid updated_from"})
+			return
+		}
+	}
+	if v := query.Get("updated_to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"success": false, "message": "invalid updated_to"})
+			return
+		}
+	}
+	statuses := make(map[string]bool)
+	for _, st := range strings.Split(query.Get("order_status"), ",") {
+		if st != "" {
+			statuses[st] = true
+		}
+	}
+	clientOrderID := query.Get("client_order_id")
+
+	s.serve(w, r, LuckyPay, OpStatus, func(sc Scenario) reply {
+		var matched []Order
+		for _, o := range s.Orders() {
+			// Окно updated_from/updated_to задаётся с точностью до секунды
+			updated := o.UpdatedAt.Truncate(time.Second)
+			if o.Provider != LuckyPay ||
+				(clientOrderID != "" && o.InvoiceID != clientOrderID) ||
+				(len(statuses) > 0 && !statuses[o.Status]) ||
+				(!from.IsZero() && updated.Before(from)) ||
+				(!to.IsZero() && updated.After(to)) {
+				continue
+			}
+			matched = append(matched, o)
+		}
+		// Поиск по client_order_id отдаёт сначала последнюю заявку
+		if clientOrderID != "" {
+			sort.SliceStable(matched, func(i, j int) bool { return matched[i].CreatedAt.After(matched[j].CreatedAt) })
+		}
+
+		pages := (len(matched) + size - 1) / size
+		items := make([]interface{}, 0, size)
+		for i := (page - 1) * size; i < len(matched) && i < page*size; i++ {
+			items = append(items, luckyPayOrder(matched[i]))
+		}
+		return reply{http.StatusOK, map[string]interface{}{
+			"success": true,
+			"orders": map[string]interface{}{
+				"items": items,
+				"page":  page,
+				"size":  size,
+				"total": len(matched),
+				"pages": pages,
+			},
+		}}
+	})
+}
+
+func (s *Server) luckyPayCancel(w http.ResponseWriter, r *http.Request) {
+	if !s.luckyPayAuthorized(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	s.serve(w, r, LuckyPay, OpCancel, func(sc Scenario) reply {
+		o, err := s.cancelPending(LuckyPay, id, "CanceledByService")
+		switch err {
+		case nil:
+			return reply{http.StatusOK, map[string]interface{}{"success": true, "order": luckyPayOrder(o)}}
+		case errOrderNotFound:
+			return reply{http.StatusNotFound, map[string]interface{}{"success": false, "message": err.Error()}}
+		default:
+			return reply{http.StatusOK, map[string]interface{}{"success": false, "message": err.Error()}}
+		}
+	})
+}
+
+// luckyPayOrder - заявка в формате LuckyPay
+func luckyPayOrder(o Order) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              o.ID,
+		"client_order_id": o.InvoiceID,
+		"order_side":      "Buy",
+		"status":          o.Status,
+		"amount":          o.Amount,
+		"holder_account":  o.Requisites,
+		"holder_name":     "Иван Петров",
+		"method_name":     o.Method,
+		"expires_at":      o.ExpiresAt.Format("2006-01-02 15:04:05"),
+		"created_at":      o.CreatedAt.Format(time.RFC3339),
+		"updated_at":      o.UpdatedAt.Format(time.RFC3339),
+	}
+}
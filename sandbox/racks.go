@@ -0,0 +1,73 @@
+package sandbox
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mountRacks: создание - GET /fiat_api с параметрами в query, статус - POST /flat_api/status?id=.
+// Ключ в Authorization: Bearer, callback подписан X-Signature = HMAC-SHA256 тела на private_key заявки
+func (s *Server) mountRacks() {
+	s.mux.HandleFunc("GET /racks/fiat_api", s.racksCreate)
+	s.mux.HandleFunc("POST /racks/flat_api/status", s.racksStatus)
+}
+
+func (s *Server) racksAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if key := s.credentials(Racks).APIKey; key != "" && r.Header.Get("Authorization") != "Bearer "+key {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"msg_error": "unauthorized", "order": []interface{}{}})
+		return false
+	}
+	return true
+}
+
+func (s *Server) racksCreate(w http.ResponseWriter, r *http.Request) {
+	if !s.racksAuthorized(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	s.serve(w, r, Racks, OpCreate, func(sc Scenario) reply {
+		fail := func(msg string) reply {
+			return reply{http.StatusOK, map[string]interface{}{"msg_error": msg, "order": []interface{}{}}}
+		}
+
+		amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+		if err != nil || amount <= 0 {
+			return fail("invalid amount")
+		}
+		if !strings.EqualFold(query.Get("currency"), "RUB") {
+			return fail("unsupported currency")
+		}
+		privateKey := query.Get("private_key")
+		if secret := s.credentials(Racks).SecretKey; secret != "" && privateKey != secret {
+			return fail("invalid private_key")
+		}
+
+		o := s.newOrder(Racks, Order{Amount: amount, Callback: query.Get("callback"), signKey: privateKey}, sc)
+		return reply{http.StatusOK, map[string]interface{}{
+			"msg_error": "",
+			"order": []interface{}{map[string]interface{}{
+				"id":        o.ID,
+				"cart":      o.Requisites,
+				"amount":    formatAmount(o.Amount),
+				"time_unix": o.ExpiresAt.Unix(),
+			}},
+		}}
+	})
+}
+
+func (s *Server) racksStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.racksAuthorized(w, r) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	s.serve(w, r, Racks, OpStatus, func(sc Scenario) reply {
+		o, ok := s.Order(Racks, id)
+		if !ok {
+			return reply{http.StatusNotFound, map[string]interface{}{"msg_error": errOrderNotFound.Error()}}
+		}
+		return reply{http.StatusOK, map[string]interface{}{"id": o.ID, "status": o.Status}}
+	})
+}
@@ -0,0 +1,132 @@
+package sandbox
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxBodySize - предел размера тела запроса к песочнице
+const maxBodySize = 1 << 20
+
+// timeoutLimit - сколько висит запрос по сценарию timeout, если клиент сам его не оборвал
+const timeoutLimit = 5 * time.Minute
+
+var (
+	errOrderNotFound = errors.New("order not found")
+	errOrderFinal    = errors.New("order is not pending")
+)
+
+// reply - ответ обработчика обменника
+type reply struct {
+	code int
+	body interface{}
+}
+
+// serve применяет к запросу сценарий обменника и операции, затем отдаёт ответ handle.
+// handle не вызывается, если сценарий отвечает кодом ошибки или запрос оборвался
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, provider, operation string, handle func(sc Scenario) reply) {
+	sc, ok := s.matchScenario(provider, operation)
+	if ok {
+		log.Printf("[sandbox %s] %s %s: сценарий %s", provider, r.Method, r.URL.Path, describe(sc))
+	}
+
+	if sc.Delay > 0 && !s.wait(r, time.Duration(sc.Delay)) {
+		return
+	}
+	if sc.Timeout {
+		s.wait(r, timeoutLimit)
+		return
+	}
+	if sc.StatusCode != 0 && sc.StatusCode != http.StatusOK {
+		writeJSON(w, sc.StatusCode, map[string]interface{}{"success": false, "message": http.StatusText(sc.StatusCode)})
+		return
+	}
+
+	res := handle(sc)
+	if sc.Malformed {
+		body, _ := json.Marshal(res.body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(res.code)
+		w.Write(body[:len(body)/2])
+		return
+	}
+	writeJSON(w, res.code, res.body)
+}
+
+// wait ждёт d, false - клиент оборвал запрос или песочница остановлена
+func (s *Server) wait(r *http.Request, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	case <-s.done:
+		return false
+	}
+}
+
+func describe(sc Scenario) string {
+	switch {
+	case sc.Timeout:
+		return "timeout"
+	case sc.StatusCode != 0:
+		return strconv.Itoa(sc.StatusCode)
+	case sc.Malformed:
+		return "malformed"
+	case sc.Delay > 0:
+		return "delay " + time.Duration(sc.Delay).String()
+	case sc.PayAfter > 0:
+		return "pay_after " + time.Duration(sc.PayAfter).String()
+	case sc.CancelAfter > 0:
+		return "cancel_after " + time.Duration(sc.CancelAfter).String()
+	default:
+		return "без изменений"
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+}
+
+func signSHA512(key string, body []byte) string {
+	return sign(sha512.New, key, body)
+}
+
+func signSHA256(key string, body []byte) string {
+	return sign(sha256.New, key, body)
+}
+
+func sign(h func() hash.Hash, key string, body []byte) string {
+	mac := hmac.New(h, []byte(key))
+	mac.Write(body)
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// formatAmount - сумма строкой с копейками, как её отдают Greengo и Racks
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// credentials возвращает ключи, которые песочница ждёт от сервиса для обменника
+func (s *Server) credentials(provider string) Credentials {
+	return s.cfg.Credentials[provider]
+}
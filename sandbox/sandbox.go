@@ -0,0 +1,395 @@
+// Package sandbox эмулирует API обменников Bitloga, Greengo, LuckyPay и Racks так, как их вызывает
+// пакет exchanger: подписи, проверка статусов, отмена и callback. Хранит заявки в памяти и
+// позволяет сценариями задавать оплату через N секунд, таймауты, ошибки 500, битый JSON и медленные ответы
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Имена обменников совпадают с именами в реестре exchanger
+const (
+	Bitloga  = "Bitloga"
+	Greengo  = "Greengo"
+	LuckyPay = "LuckyPay"
+	Racks    = "Racks"
+)
+
+// Providers - эмулируемые обменники
+var Providers = []string{Bitloga, Greengo, LuckyPay, Racks}
+
+// Операции, к которым привязываются сценарии
+const (
+	OpCreate   = "create"
+	OpStatus   = "status"
+	OpCancel   = "cancel"
+	OpCallback = "callback"
+)
+
+// lifecycle - статусы заявки в терминах обменника
+type lifecycle struct {
+	pending  string
+	paid     string
+	canceled string
+}
+
+var lifecycles = map[string]lifecycle{
+	Bitloga:  {pending: "Pending", paid: "Payed", canceled: "Canceled"},
+	Greengo:  {pending: "awaiting", paid: "completed", canceled: "autocanceled"},
+	LuckyPay: {pending: "Pending", paid: "Completed", canceled: "CanceledByTimeout"},
+	Racks:    {pending: "Pending", paid: "Done", canceled: "Cancel"},
+}
+
+// Credentials - ключи, которые песочница ожидает от сервиса. Пустой APIKey - ключ не проверяется,
+// пустой SecretKey - подпись Bitloga не проверяется
+type Credentials struct {
+	APIKey    string
+	SecretKey string
+}
+
+// Config - настройки песочницы
+type Config struct {
+	Credentials map[string]Credentials // по имени обменника
+	PayAfter    time.Duration          // через сколько заявка оплачивается сама, 0 - только по сценарию или вручную
+	OrderTTL    time.Duration          // через сколько неоплаченная заявка отменяется
+	Tick        time.Duration          // период продвижения заявок по статусам
+	Scenarios   []Scenario             // сценарии, заданные при запуске
+}
+
+// Order - заявка в песочнице
+type Order struct {
+	Provider   string    `json:"provider"`
+	ID         string    `json:"id"`
+	InvoiceID  string    `json:"invoice_id,omitempty"` // uniqueid Bitloga, client_order_id LuckyPay
+	Amount     float64   `json:"amount"`
+	Requisites string    `json:"requisites"`
+	Method     string    `json:"method,omitempty"`
+	Status     string    `json:"status"`
+	Callback   string    `json:"callback,omitempty"`
+	PayAt      time.Time `json:"pay_at,omitzero"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	signKey string // ключ подписи callback: SecretKey у Bitloga, private_key заявки у Racks
+}
+
+// Server - песочница обменников. Маршруты обменника начинаются с /<имя в нижнем регистре>,
+// например Endpoint обменника Bitloga - http://host:port/bitloga
+type Server struct {
+	cfg    Config
+	mux    *http.ServeMux
+	client *http.Client
+
+	mu        sync.Mutex
+	orders    map[string]*Order
+	seq       uint64
+	scenarios []*activeScenario
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New создаёт песочницу и запускает продвижение заявок по статусам
+func New(cfg Config) *Server {
+	if cfg.OrderTTL <= 0 {
+		cfg.OrderTTL = 20 * time.Minute
+	}
+	if cfg.Tick <= 0 {
+		cfg.Tick = time.Second
+	}
+
+	s := &Server{
+		cfg:    cfg,
+		mux:    http.NewServeMux(),
+		client: &http.Client{Timeout: 8 * time.Second},
+		orders: make(map[string]*Order),
+		seq:    100000,
+		done:   make(chan struct{}),
+	}
+	for _, sc := range cfg.Scenarios {
+		s.AddScenario(sc)
+	}
+
+	s.mountBitloga()
+	s.mountGreengo()
+	s.mountLuckyPay()
+	s.mountRacks()
+	s.mountControl()
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close останавливает продвижение заявок и прерывает зависшие по сценарию timeout ответы
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
+
+// Orders возвращает копии всех заявок в порядке создания
+func (s *Server) Orders() []Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := make([]Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, *o)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	return orders
+}
+
+// Order возвращает копию заявки обменника по её ID
+func (s *Server) Order(provider, id string) (Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderKey(provider, id)]
+	if !ok {
+		return Order{}, false
+	}
+	return *o, true
+}
+
+// Pay переводит заявку в статус оплаты и отправляет callback, если обменник их шлёт
+func (s *Server) Pay(provider, id string) error {
+	return s.SetStatus(provider, id, lifecycles[provider].paid)
+}
+
+// Cancel переводит заявку в статус отмены по времени и отправляет callback
+func (s *Server) Cancel(provider, id string) error {
+	return s.SetStatus(provider, id, lifecycles[provider].canceled)
+}
+
+// SetStatus выставляет заявке произвольный статус обменника, например "payed" у Greengo
+func (s *Server) SetStatus(provider, id, status string) error {
+	s.mu.Lock()
+	o, ok := s.orders[orderKey(provider, id)]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("заявка %s %s не найдена", provider, id)
+	}
+	o.Status = status
+	o.UpdatedAt = time.Now().UTC()
+	snapshot := *o
+	s.mu.Unlock()
+
+	s.notify(snapshot)
+	return nil
+}
+
+// Reset удаляет все заявки и сценарии
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders = make(map[string]*Order)
+	s.scenarios = nil
+}
+
+// newOrder сохраняет заявку в статусе ожидания. Срок оплаты и автооплата берутся из
+// сценария create, если он их задаёт, иначе из Config
+func (s *Server) newOrder(provider string, o Order, sc Scenario) Order {
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	switch provider {
+	case Greengo, Racks:
+		o.ID = fmt.Sprintf("%d", s.seq)
+	case Bitloga:
+		o.ID = fmt.Sprintf("BL%d", s.seq)
+	case LuckyPay:
+		o.ID = fmt.Sprintf("%08x-4c6b-4a70-9a1e-%012x", s.seq, now.UnixNano()&0xffffffffffff)
+	}
+	o.Provider = provider
+	o.Requisites = fmt.Sprintf("2200 7001 %04d %04d", s.seq/10000%10000, s.seq%10000)
+	o.Status = lifecycles[provider].pending
+	o.CreatedAt = now
+	o.UpdatedAt = now
+
+	ttl := s.cfg.OrderTTL
+	if sc.CancelAfter > 0 {
+		ttl = time.Duration(sc.CancelAfter)
+	}
+	o.ExpiresAt = now.Add(ttl)
+
+	payAfter := s.cfg.PayAfter
+	if sc.PayAfter > 0 {
+		payAfter = time.Duration(sc.PayAfter)
+	}
+	if payAfter > 0 {
+		o.PayAt = now.Add(payAfter)
+	}
+
+	s.orders[orderKey(provider, o.ID)] = &o
+	return o
+}
+
+// findByInvoice возвращает последнюю заявку обменника по ID нашего счета
+func (s *Server) findByInvoice(provider, invoiceID string) (Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found *Order
+	for _, o := range s.orders {
+		if o.Provider == provider && o.InvoiceID == invoiceID && (found == nil || o.CreatedAt.After(found.CreatedAt)) {
+			found = o
+		}
+	}
+	if found == nil {
+		return Order{}, false
+	}
+	return *found, true
+}
+
+// cancelPending отменяет ожидающую оплаты заявку по запросу сервиса
+func (s *Server) cancelPending(provider, id, status string) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderKey(provider, id)]
+	if !ok {
+		return Order{}, errOrderNotFound
+	}
+	if o.Status != lifecycles[provider].pending {
+		return *o, errOrderFinal
+	}
+	o.Status = status
+	o.UpdatedAt = time.Now().UTC()
+	return *o, nil
+}
+
+func (s *Server) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.Tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			for _, o := range s.advance(now.UTC()) {
+				s.notify(o)
+			}
+		}
+	}
+}
+
+// advance оплачивает заявки с наступившим PayAt и отменяет просроченные
+func (s *Server) advance(now time.Time) []Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changed []Order
+	for _, o := range s.orders {
+		lc := lifecycles[o.Provider]
+		if o.Status != lc.pending {
+			continue
+		}
+		switch {
+		case !o.PayAt.IsZero() && !now.Before(o.PayAt) && o.PayAt.Before(o.ExpiresAt):
+			o.Status = lc.paid
+		case !now.Before(o.ExpiresAt):
+			o.Status = lc.canceled
+		default:
+			continue
+		}
+		o.UpdatedAt = now
+		changed = append(changed, *o)
+	}
+	return changed
+}
+
+// notify отправляет callback о смене статуса заявки Bitloga и Racks, остальные обменники их не шлют
+func (s *Server) notify(o Order) {
+	if o.Callback == "" || (o.Provider != Bitloga && o.Provider != Racks) {
+		return
+	}
+	log.Printf("[sandbox %s] заявка %s: %s", o.Provider, o.ID, o.Status)
+
+	sc, _ := s.matchScenario(o.Provider, OpCallback)
+	if sc.Drop {
+		log.Printf("[sandbox %s] callback заявки %s не отправлен по сценарию", o.Provider, o.ID)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if sc.Delay > 0 {
+			select {
+			case <-time.After(time.Duration(sc.Delay)):
+			case <-s.done:
+				return
+			}
+		}
+		if err := s.sendCallback(o); err != nil {
+			log.Printf("[sandbox %s] callback заявки %s: %v", o.Provider, o.ID, err)
+		}
+	}()
+}
+
+func (s *Server) sendCallback(o Order) error {
+	var payload map[string]interface{}
+	var header, signature string
+
+	switch o.Provider {
+	case Bitloga:
+		payload = map[string]interface{}{"invoiceid": o.ID, "uniqueid": o.InvoiceID, "status": o.Status}
+		header = "X-SIGNATURE"
+	case Racks:
+		payload = map[string]interface{}{"id": o.ID, "status": o.Status}
+		header = "X-Signature"
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if o.Provider == Bitloga {
+		signature = signSHA512(o.signKey, body)
+	} else {
+		signature = signSHA256(o.signKey, body)
+	}
+
+	req, err := http.NewRequest("POST", o.Callback, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(header, signature)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("сервис ответил %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func orderKey(provider, id string) string {
+	return strings.ToLower(provider) + "/" + id
+}
@@ -0,0 +1,174 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scenario - поведение песочницы для запросов к обменнику. Для каждого запроса берётся первый
+// подходящий сценарий, после Times срабатываний он удаляется
+type Scenario struct {
+	Provider  string `json:"provider"`  // имя обменника, пусто или "*" - любой
+	Operation string `json:"operation"` // create, status, cancel, callback; пусто или "*" - любая
+	Times     int    `json:"times"`     // сколько раз сработать, 0 - без ограничения
+
+	Delay      Duration `json:"delay"`       // медленный ответ или задержка callback
+	Timeout    bool     `json:"timeout"`     // не отвечать, пока клиент не оборвёт запрос
+	StatusCode int      `json:"status_code"` // ответить этим кодом без обработки, например 500
+	Malformed  bool     `json:"malformed"`   // отдать обрезанный JSON
+
+	PayAfter    Duration `json:"pay_after"`    // create: оплатить заявку через заданное время
+	CancelAfter Duration `json:"cancel_after"` // create: отменить неоплаченную заявку через заданное время
+	Drop        bool     `json:"drop"`         // callback: не отправлять уведомление
+}
+
+// Duration - time.Duration в JSON: строка "5s" или число секунд
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	case nil:
+		*d = 0
+	default:
+		return fmt.Errorf("длительность должна быть строкой или числом секунд: %s", data)
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type activeScenario struct {
+	Scenario
+	left int // оставшиеся срабатывания, если Times > 0
+}
+
+// AddScenario добавляет сценарий в конец списка
+func (s *Server) AddScenario(sc Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenarios = append(s.scenarios, &activeScenario{Scenario: sc, left: sc.Times})
+}
+
+// Scenarios возвращает действующие сценарии, Times - оставшиеся срабатывания
+func (s *Server) Scenarios() []Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scenarios := make([]Scenario, 0, len(s.scenarios))
+	for _, a := range s.scenarios {
+		sc := a.Scenario
+		sc.Times = a.left
+		scenarios = append(scenarios, sc)
+	}
+	return scenarios
+}
+
+// ClearScenarios удаляет все сценарии
+func (s *Server) ClearScenarios() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenarios = nil
+}
+
+// matchScenario находит первый сценарий для обменника и операции и списывает одно срабатывание
+func (s *Server) matchScenario(provider, operation string) (Scenario, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, a := range s.scenarios {
+		if !matches(a.Provider, provider) || !matches(a.Operation, operation) {
+			continue
+		}
+		if a.Times > 0 {
+			a.left--
+			if a.left <= 0 {
+				s.scenarios = append(s.scenarios[:i:i], s.scenarios[i+1:]...)
+			}
+		}
+		return a.Scenario, true
+	}
+	return Scenario{}, false
+}
+
+func matches(pattern, value string) bool {
+	return pattern == "" || pattern == "*" || strings.EqualFold(pattern, value)
+}
+
+// LoadScenarios читает сценарии из JSON-файла: массив Scenario
+func LoadScenarios(path string) ([]Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseScenarios(data)
+}
+
+// parseScenarios принимает один сценарий или массив
+func parseScenarios(data []byte) ([]Scenario, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return nil, errors.New("пустой сценарий")
+	}
+
+	var scenarios []Scenario
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &scenarios); err != nil {
+			return nil, err
+		}
+	} else {
+		var sc Scenario
+		if err := json.Unmarshal(data, &sc); err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, sc)
+	}
+
+	for _, sc := range scenarios {
+		if err := sc.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return scenarios, nil
+}
+
+func (sc Scenario) validate() error {
+	if sc.Provider != "" && sc.Provider != "*" {
+		known := false
+		for _, p := range Providers {
+			known = known || strings.EqualFold(p, sc.Provider)
+		}
+		if !known {
+			return fmt.Errorf("сценарий: неизвестный обменник %q", sc.Provider)
+		}
+	}
+	switch strings.ToLower(sc.Operation) {
+	case "", "*", OpCreate, OpStatus, OpCancel, OpCallback:
+	default:
+		return fmt.Errorf("сценарий: неизвестная операция %q", sc.Operation)
+	}
+	if sc.StatusCode != 0 && (sc.StatusCode < 100 || sc.StatusCode > 599) {
+		return fmt.Errorf("сценарий: некорректный status_code %d", sc.StatusCode)
+	}
+	if sc.Times < 0 || sc.Delay < 0 || sc.PayAfter < 0 || sc.CancelAfter < 0 {
+		return errors.New("сценарий: times и длительности не могут быть отрицательными")
+	}
+	return nil
+}
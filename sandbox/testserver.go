@@ -0,0 +1,42 @@
+package sandbox
+
+import (
+	"net/http/httptest"
+	"payment-service-go/models"
+	"strings"
+)
+
+// TestServer - песочница на httptest.Server для тестов сервиса
+type TestServer struct {
+	*Server
+	HTTP *httptest.Server
+}
+
+// NewTestServer запускает песочницу на случайном локальном порту
+func NewTestServer(cfg Config) *TestServer {
+	s := New(cfg)
+	return &TestServer{Server: s, HTTP: httptest.NewServer(s)}
+}
+
+// Endpoint - адрес обменника в песочнице, который подставляется в models.Exchanger.Endpoint
+func (t *TestServer) Endpoint(provider string) string {
+	return t.HTTP.URL + "/" + strings.ToLower(provider)
+}
+
+// Exchanger - настройки обменника с адресом и ключами песочницы
+func (t *TestServer) Exchanger(id uint32, provider string) models.Exchanger {
+	creds := t.credentials(provider)
+	return models.Exchanger{
+		ID:        id,
+		Name:      provider,
+		Endpoint:  t.Endpoint(provider),
+		APIKey:    creds.APIKey,
+		SecretKey: creds.SecretKey,
+	}
+}
+
+// Close останавливает HTTP-сервер и песочницу
+func (t *TestServer) Close() {
+	t.Server.Close()
+	t.HTTP.Close()
+}